@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"public_exporter/collector"
+	"public_exporter/config"
+	"sort"
+	"sync"
+)
+
+// collectorCheck is the outcome of checking one collector
+type collectorCheck struct {
+	cluster   string
+	collector string
+	problems  []string
+	warnings  []string
+}
+
+// checkConfigCommand validates the configuration and the scripts it refers
+// to without starting the exporter, and returns the exit code.
+func checkConfigCommand(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config.file", configPath, "Path to configuration file")
+	dryRun := fs.Bool("dry-run", false, "Run every enabled collector once and validate its output")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s check-config [flags]\n\nValidates the configuration and the scripts of all enabled collectors.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Printf("Configuration %s: FAILED\n%v\n", configPath, err)
+		return 1
+	}
+	fmt.Printf("Configuration %s: OK\n", configPath)
+	for _, file := range cfg.Files[1:] {
+		fmt.Printf("  included %s\n", file)
+	}
+
+	checks := checkCollectors(cfg, *dryRun)
+	failed := 0
+	for _, check := range checks {
+		status := "OK"
+		if len(check.problems) > 0 {
+			status = "FAILED"
+			failed++
+		}
+		fmt.Printf("%s/%s: %s\n", check.cluster, check.collector, status)
+		for _, problem := range check.problems {
+			fmt.Printf("  error: %s\n", problem)
+		}
+		for _, warning := range check.warnings {
+			fmt.Printf("  warning: %s\n", warning)
+		}
+	}
+
+	fmt.Printf("\n%d collectors checked, %d failed\n", len(checks), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkCollectors checks all enabled collectors, running their scripts if
+// dryRun is set, at most max_concurrent_scripts at a time.
+func checkCollectors(cfg *config.Config, dryRun bool) []*collectorCheck {
+	var checks []*collectorCheck
+	for clusterName, clusterCfg := range cfg.Clusters {
+		if !clusterCfg.Enabled {
+			continue
+		}
+		for collectorName, collectorCfg := range clusterCfg.Collectors {
+			if collectorCfg.Enabled {
+				checks = append(checks, &collectorCheck{cluster: clusterName, collector: collectorName})
+			}
+		}
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		if checks[i].cluster != checks[j].cluster {
+			return checks[i].cluster < checks[j].cluster
+		}
+		return checks[i].collector < checks[j].collector
+	})
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, cfg.Global.MaxConcurrentScripts)
+	for _, check := range checks {
+		check.problems = checkScript(cfg, cfg.Clusters[check.cluster].Collectors[check.collector])
+		if !dryRun || len(check.problems) > 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(check *collectorCheck) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			dryRunCollector(cfg, check)
+		}(check)
+	}
+	wg.Wait()
+	return checks
+}
+
+// checkScript checks that a collector's script and interpreter can be run
+func checkScript(cfg *config.Config, collectorCfg config.CollectorConfig) []string {
+	var problems []string
+
+	if scriptFile := collectorCfg.ScriptFile(); scriptFile != "" {
+		info, err := os.Stat(scriptFile)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("script: %v", err))
+		case info.IsDir():
+			problems = append(problems, fmt.Sprintf("script %s is a directory", scriptFile))
+		default:
+			if file, err := os.Open(scriptFile); err != nil {
+				problems = append(problems, fmt.Sprintf("script is not readable: %v", err))
+			} else {
+				file.Close()
+			}
+			if collectorCfg.ScriptType == config.ScriptTypeExec && info.Mode()&0111 == 0 {
+				problems = append(problems, fmt.Sprintf("script %s is not executable", scriptFile))
+			}
+		}
+	}
+
+	interpreter, err := cfg.InterpreterCommand(collectorCfg)
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else if len(interpreter) > 0 {
+		if _, err := exec.LookPath(interpreter[0]); err != nil {
+			problems = append(problems, fmt.Sprintf("interpreter %s not found: %v", interpreter[0], err))
+		}
+	}
+
+	if collectorCfg.Workdir != "" {
+		if info, err := os.Stat(collectorCfg.Workdir); err != nil {
+			problems = append(problems, fmt.Sprintf("workdir: %v", err))
+		} else if !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("workdir %s is not a directory", collectorCfg.Workdir))
+		}
+	}
+
+	if collectorCfg.EnvFromFile != "" {
+		if _, err := os.Stat(collectorCfg.EnvFromFile); err != nil {
+			problems = append(problems, fmt.Sprintf("env_from_file: %v", err))
+		}
+	}
+
+	return problems
+}
+
+// dryRunCollector runs a collector once and records problems with its output
+func dryRunCollector(cfg *config.Config, check *collectorCheck) {
+	collectorCfg := cfg.Clusters[check.cluster].Collectors[check.collector]
+	_, output, invalid := collector.RunOnce(cfg, check.cluster, check.collector, collectorCfg)
+	if output.Error != nil {
+		check.problems = append(check.problems, output.Error.Error())
+		if output.Stderr != "" {
+			check.warnings = append(check.warnings, fmt.Sprintf("stderr: %s", output.Stderr))
+		}
+		return
+	}
+	for _, parseErr := range invalid {
+		check.problems = append(check.problems, fmt.Sprintf("invalid output: %v", parseErr))
+	}
+	if len(output.Families) == 0 {
+		check.warnings = append(check.warnings, "the script printed no metrics")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -11,9 +12,11 @@ import (
 	"public_exporter/config"
 	"public_exporter/collector"
 	"public_exporter/service"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
+	_ "time/tzdata" // time zones of schedules, on systems without zoneinfo
 )
 
 const (
@@ -23,14 +26,32 @@ const (
 	Version = "1.0.0"
 )
 
+// scrapeTimeoutOffset is kept from a scrape's timeout for rendering and
+// sending the response.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
 var configPath string
 
 func init() {
 	flag.StringVar(&configPath, "config.file", "/app/config/config.yaml", "Path to configuration file")
-	flag.Parse()
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [flags] [command]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Without a command the exporter is started. Commands:")
+		fmt.Fprintln(out, "  check-config, validate   Validate the configuration and collector scripts")
+		fmt.Fprintln(out, "  run                      Run a single collector once and print its metrics")
+		fmt.Fprintln(out, "  schema                   Print the JSON Schema of the configuration file")
+		fmt.Fprintln(out, "\nFlags:")
+		flag.PrintDefaults()
+	}
 }
 
 func main() {
+	flag.Parse()
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
+
 	fmt.Println("=====================================")
 	fmt.Println("         Public Exporter             ")
 	fmt.Printf("         Version: %s                \n", Version)
@@ -54,26 +75,33 @@ func main() {
 
 	// Create and start services
 	collectorManager := collector.NewCollectorManager(cfg)
-	exporterService := service.NewExporterService(cfg, collectorManager)
+	exporterService := service.NewExporterService(cfg, configPath, collectorManager)
 	
 	if err := exporterService.Start(); err != nil {
 		log.Fatalf("Failed to start exporter service: %v", err)
 	}
 
 	// Setup HTTP server
-	server := setupHTTPServer(cfg, collectorManager)
+	server := setupHTTPServer(cfg, collectorManager, exporterService)
 	
+	// Reload the configuration on SIGHUP
+	go func() {
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		for range hupChan {
+			log.Println("Received SIGHUP, reloading configuration...")
+			exporterService.Reload()
+		}
+	}()
+
 	// Setup graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	
+	stopped := make(chan struct{})
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
 		
 		log.Println("Received shutdown signal, starting graceful shutdown...")
-		cancel()
 		
 		// Give some time for graceful shutdown
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -85,7 +113,7 @@ func main() {
 		
 		exporterService.Stop()
 		log.Println("Graceful shutdown completed")
-		os.Exit(0)
+		close(stopped)
 	}()
 
 	port := cfg.Global.HTTPPort
@@ -97,6 +125,24 @@ func main() {
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	// Wait for the running scripts to be terminated
+	<-stopped
+}
+
+// runCommand runs a command-line mode other than the exporter itself and
+// returns the exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "check-config", "validate":
+		return checkConfigCommand(args[1:])
+	case "run":
+		return runCollectorCommand(args[1:])
+	case "schema":
+		return schemaCommand(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[0])
+	flag.Usage()
+	return 2
 }
 
 func setupLogging(cfg *config.Config) error {
@@ -110,7 +156,21 @@ func setupLogging(cfg *config.Config) error {
 	return nil
 }
 
-func setupHTTPServer(cfg *config.Config, collectorManager *collector.CollectorManager) *http.Server {
+// scrapeContext returns the context of a scrape, ending before the timeout
+// Prometheus announces in its request so the response still arrives in time.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > 2*scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+func setupHTTPServer(cfg *config.Config, collectorManager *collector.CollectorManager, exporterService *service.ExporterService) *http.Server {
 	mux := http.NewServeMux()
 	
 	// Metrics endpoint
@@ -118,22 +178,169 @@ func setupHTTPServer(cfg *config.Config, collectorManager *collector.CollectorMa
 		var outputs []string
 		globalHealthy := 1
 
-		// Get collector outputs
-		collectorOutputs := collectorManager.GetOutputs()
-		outputs = append(outputs, collectorOutputs...)
+		// Run the on_scrape collectors within the scraper's timeout
+		ctx, cancel := scrapeContext(r)
+		collectorManager.CollectOnScrape(ctx)
+		cancel()
+
+		// Get collector metrics, grouped by metric family
+		outputs = append(outputs, collector.FormatMetrics(collectorManager.GatherFamilies()))
 
 		// Get health status
+		outputs = append(outputs, `# HELP collector_health_status Health status of each collector (1 = ok, 0 = failed)`)
+		outputs = append(outputs, `# TYPE collector_health_status gauge`)
 		healthStatus := collectorManager.GetHealthStatus()
 		for key, health := range healthStatus {
-			parts := strings.Split(key, ":")
-			if len(parts) == 2 {
-				cluster, name := parts[0], parts[1]
-				if health == 0 {
-					globalHealthy = 0
+			if health == 0 {
+				globalHealthy = 0
+			}
+			outputs = append(outputs, fmt.Sprintf(`collector_health_status{%s} %d`, collector.SeriesLabels(key), health))
+		}
+
+		// Add invalid output line counters
+		outputs = append(outputs, `# HELP collector_invalid_lines_total Total number of invalid script output lines dropped`)
+		outputs = append(outputs, `# TYPE collector_invalid_lines_total counter`)
+		for key, count := range collectorManager.GetInvalidLineCounts() {
+			outputs = append(outputs, fmt.Sprintf(`collector_invalid_lines_total{%s} %d`, collector.SeriesLabels(key), count))
+		}
+
+		// Add series skipped when merging the collectors' families
+		outputs = append(outputs, `# HELP collector_conflicting_series Series of a collector skipped at the last scrape because another collector exposes them, or their metric with another type`)
+		outputs = append(outputs, `# TYPE collector_conflicting_series gauge`)
+		for key, count := range collectorManager.GetConflictCounts() {
+			outputs = append(outputs, fmt.Sprintf(`collector_conflicting_series{%s} %d`, collector.SeriesLabels(key), count))
+		}
+
+		// Add orphaned process kill counters
+		outputs = append(outputs, `# HELP collector_orphan_kills_total Number of times processes left behind by a script were killed`)
+		outputs = append(outputs, `# TYPE collector_orphan_kills_total counter`)
+		for key, count := range collectorManager.GetOrphanKillCounts() {
+			outputs = append(outputs, fmt.Sprintf(`collector_orphan_kills_total{%s} %d`, collector.SeriesLabels(key), count))
+		}
+
+		// Add worker pool metrics
+		outputs = append(outputs, `# HELP collector_queue_wait_seconds Time the last execution of a collector waited for a free worker`)
+		outputs = append(outputs, `# TYPE collector_queue_wait_seconds gauge`)
+		for key, wait := range collectorManager.GetQueueWaitSeconds() {
+			outputs = append(outputs, fmt.Sprintf(`collector_queue_wait_seconds{%s} %g`, collector.SeriesLabels(key), wait))
+		}
+		queued, running := collectorManager.GetWorkerStats()
+		outputs = append(outputs, `# HELP collector_scripts_queued Number of scripts waiting for a free worker`)
+		outputs = append(outputs, `# TYPE collector_scripts_queued gauge`)
+		outputs = append(outputs, fmt.Sprintf("collector_scripts_queued %d", queued))
+		outputs = append(outputs, `# HELP collector_scripts_running Number of scripts currently running`)
+		outputs = append(outputs, `# TYPE collector_scripts_running gauge`)
+		outputs = append(outputs, fmt.Sprintf("collector_scripts_running %d", running))
+
+		// Add execution metrics
+		executions := collectorManager.GetExecutionStats()
+		outputs = append(outputs, `# HELP collector_execution_duration_seconds Duration of collector script executions`)
+		outputs = append(outputs, `# TYPE collector_execution_duration_seconds histogram`)
+		for key, stats := range executions {
+			labels := collector.SeriesLabels(key)
+			for i, bound := range collector.DurationBuckets {
+				outputs = append(outputs, fmt.Sprintf(`collector_execution_duration_seconds_bucket{%s, le="%s"} %d`, labels, strconv.FormatFloat(bound, 'g', -1, 64), stats.DurationCounts[i]))
+			}
+			outputs = append(outputs, fmt.Sprintf(`collector_execution_duration_seconds_bucket{%s, le="+Inf"} %d`, labels, stats.Runs))
+			outputs = append(outputs, fmt.Sprintf(`collector_execution_duration_seconds_sum{%s} %g`, labels, stats.DurationSum))
+			outputs = append(outputs, fmt.Sprintf(`collector_execution_duration_seconds_count{%s} %d`, labels, stats.Runs))
+		}
+		outputs = append(outputs, `# HELP collector_runs_total Number of collector script executions`)
+		outputs = append(outputs, `# TYPE collector_runs_total counter`)
+		for key, stats := range executions {
+			outputs = append(outputs, fmt.Sprintf(`collector_runs_total{%s} %d`, collector.SeriesLabels(key), stats.Runs))
+		}
+		outputs = append(outputs, `# HELP collector_failures_total Number of failed collector script executions by reason`)
+		outputs = append(outputs, `# TYPE collector_failures_total counter`)
+		for key, stats := range executions {
+			labels := collector.SeriesLabels(key)
+			for _, reason := range collector.FailureReasons {
+				outputs = append(outputs, fmt.Sprintf(`collector_failures_total{%s, reason="%s"} %d`, labels, reason, stats.Failures[reason]))
+			}
+		}
+		outputs = append(outputs, `# HELP collector_last_exit_code Exit code of the last execution of a collector script (-1 if it did not start or was killed)`)
+		outputs = append(outputs, `# TYPE collector_last_exit_code gauge`)
+		for key, stats := range executions {
+			outputs = append(outputs, fmt.Sprintf(`collector_last_exit_code{%s} %d`, collector.SeriesLabels(key), stats.LastExitCode))
+		}
+		outputs = append(outputs, `# HELP collector_last_success_timestamp_seconds Time of the last successful execution of a collector (0 if none succeeded)`)
+		outputs = append(outputs, `# TYPE collector_last_success_timestamp_seconds gauge`)
+		for key, stats := range executions {
+			labels := collector.SeriesLabels(key)
+			var lastSuccess int64
+			if !stats.LastSuccess.IsZero() {
+				lastSuccess = stats.LastSuccess.Unix()
+			}
+			outputs = append(outputs, fmt.Sprintf(`collector_last_success_timestamp_seconds{%s} %d`, labels, lastSuccess))
+		}
+		outputs = append(outputs, `# HELP collector_output_bytes Size of the output of the last execution of a collector script`)
+		outputs = append(outputs, `# TYPE collector_output_bytes gauge`)
+		for key, stats := range executions {
+			outputs = append(outputs, fmt.Sprintf(`collector_output_bytes{%s} %d`, collector.SeriesLabels(key), stats.OutputBytes))
+		}
+
+		// Add retry and circuit breaker metrics
+		outputs = append(outputs, `# HELP collector_retries_total Number of retries of failed collector runs`)
+		outputs = append(outputs, `# TYPE collector_retries_total counter`)
+		for key, count := range collectorManager.GetRetryCounts() {
+			outputs = append(outputs, fmt.Sprintf(`collector_retries_total{%s} %d`, collector.SeriesLabels(key), count))
+		}
+		circuits, failures := collectorManager.GetCircuitStates()
+		outputs = append(outputs, `# HELP collector_circuit_state State of the circuit breaker of a collector (1 for the current state)`)
+		outputs = append(outputs, `# TYPE collector_circuit_state gauge`)
+		for key, current := range circuits {
+			labels := collector.SeriesLabels(key)
+			for _, state := range collector.CircuitStates {
+				value := 0
+				if state == current {
+					value = 1
 				}
-				outputs = append(outputs, fmt.Sprintf(`collector_health_status{cluster="%s", collector="%s"} %d`, cluster, name, health))
+				outputs = append(outputs, fmt.Sprintf(`collector_circuit_state{%s, state="%s"} %d`, labels, state, value))
 			}
 		}
+		outputs = append(outputs, `# HELP collector_consecutive_failures Number of consecutive failed runs of a collector`)
+		outputs = append(outputs, `# TYPE collector_consecutive_failures gauge`)
+		for key, count := range failures {
+			outputs = append(outputs, fmt.Sprintf(`collector_consecutive_failures{%s} %d`, collector.SeriesLabels(key), count))
+		}
+
+		// Add next scheduled runs
+		outputs = append(outputs, `# HELP collector_next_run_timestamp_seconds Time of the next scheduled execution of a collector`)
+		outputs = append(outputs, `# TYPE collector_next_run_timestamp_seconds gauge`)
+		for key, next := range collectorManager.GetNextRuns() {
+			outputs = append(outputs, fmt.Sprintf(`collector_next_run_timestamp_seconds{%s} %d`, collector.SeriesLabels(key), next.Unix()))
+		}
+
+		// Add output ages, computed at scrape time
+		ages, stale := collectorManager.GetOutputAges()
+		outputs = append(outputs, `# HELP collector_output_age_seconds Time since the output of a collector was produced`)
+		outputs = append(outputs, `# TYPE collector_output_age_seconds gauge`)
+		for key, age := range ages {
+			outputs = append(outputs, fmt.Sprintf(`collector_output_age_seconds{%s} %.3f`, collector.SeriesLabels(key), age))
+		}
+		outputs = append(outputs, `# HELP collector_output_stale Whether the output of a collector with stale_action metric is older than max_age and not served`)
+		outputs = append(outputs, `# TYPE collector_output_stale gauge`)
+		for key, isStale := range stale {
+			labels := collector.SeriesLabels(key)
+			value := 0
+			if isStale {
+				value = 1
+			}
+			outputs = append(outputs, fmt.Sprintf(`collector_output_stale{%s} %d`, labels, value))
+		}
+
+		// Add configuration reload status
+		reloadSuccessful, reloadTime := exporterService.ReloadStatus()
+		reloadSuccess := 0
+		if reloadSuccessful {
+			reloadSuccess = 1
+		}
+		outputs = append(outputs, `# HELP config_last_reload_successful Whether the last configuration reload attempt was successful`)
+		outputs = append(outputs, `# TYPE config_last_reload_successful gauge`)
+		outputs = append(outputs, fmt.Sprintf("config_last_reload_successful %d", reloadSuccess))
+		outputs = append(outputs, `# HELP config_last_reload_timestamp_seconds Timestamp of the last successful configuration reload`)
+		outputs = append(outputs, `# TYPE config_last_reload_timestamp_seconds gauge`)
+		outputs = append(outputs, fmt.Sprintf("config_last_reload_timestamp_seconds %d", reloadTime.Unix()))
 
 		// Add exporter health status
 		outputs = append(outputs, `# HELP exporter_health_status Global health status of the exporter`)
@@ -156,6 +363,7 @@ func setupHTTPServer(cfg *config.Config, collectorManager *collector.CollectorMa
 		collectorStatuses := make(map[string]string)
 
 		healthStatus := collectorManager.GetHealthStatus()
+		circuits, _ := collectorManager.GetCircuitStates()
 		for key, health := range healthStatus {
 			if health == 0 {
 				collectorStatuses[key] = "failed"
@@ -163,6 +371,10 @@ func setupHTTPServer(cfg *config.Config, collectorManager *collector.CollectorMa
 			} else {
 				collectorStatuses[key] = "ok"
 			}
+			// A collector whose circuit breaker is not closed shows its state
+			if circuit, ok := circuits[key]; ok && circuit != collector.CircuitClosed {
+				collectorStatuses[key] = circuit
+			}
 		}
 
 		output := fmt.Sprintf(`{"status":"%s", "collectors":{`, globalHealthy)
@@ -177,6 +389,46 @@ func setupHTTPServer(cfg *config.Config, collectorManager *collector.CollectorMa
 		w.Write([]byte(output))
 	})
 
+	// Configuration reload endpoint
+	mux.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "This endpoint requires a POST or PUT request.", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := exporterService.Reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Collector state endpoint, optionally filtered by ?cluster= and ?collector=
+	mux.HandleFunc("/api/collectors", func(w http.ResponseWriter, r *http.Request) {
+		cluster := r.URL.Query().Get("cluster")
+		name := r.URL.Query().Get("collector")
+
+		states := []collector.CollectorState{}
+		for _, state := range collectorManager.GetCollectorStates() {
+			if (cluster == "" || state.Cluster == cluster) && (name == "" || state.Collector == name) {
+				states = append(states, state)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(states)
+	})
+
+	// Merged configuration endpoint, as loaded from the config file and its includes
+	mux.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
+		current := exporterService.CurrentConfig()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "# Loaded from: %s\n", strings.Join(current.Files, ", "))
+		w.Write(current.Merged)
+	})
+
 	// Root endpoint with basic info
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
@@ -196,6 +448,8 @@ func setupHTTPServer(cfg *config.Config, collectorManager *collector.CollectorMa
     <ul>
         <li><a href="/metrics">Metrics</a> - Prometheus metrics endpoint</li>
         <li><a href="/health">Health</a> - Health check endpoint</li>
+        <li><a href="/api/collectors">Collectors</a> - State of each collector's last execution</li>
+        <li><a href="/api/config">Config</a> - Merged configuration</li>
     </ul>
 </body>
 </html>`, Version, Author, Email)
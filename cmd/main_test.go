@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScrapeContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		timeout time.Duration // 0 for no deadline
+	}{
+		{"the header sets the deadline, less the offset", "10", 10*time.Second - scrapeTimeoutOffset},
+		{"short timeouts are kept whole", "0.8", 800 * time.Millisecond},
+		{"no header", "", 0},
+		{"invalid header", "soon", 0},
+		{"negative header", "-1", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+			}
+			begin := time.Now()
+			ctx, cancel := scrapeContext(r)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if tt.timeout == 0 {
+				if ok {
+					t.Errorf("deadline in %v, want none", deadline.Sub(begin))
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("no deadline, want one in %v", tt.timeout)
+			}
+			if timeout := deadline.Sub(begin); timeout < tt.timeout || timeout > tt.timeout+100*time.Millisecond {
+				t.Errorf("deadline in %v, want %v", timeout, tt.timeout)
+			}
+		})
+	}
+}
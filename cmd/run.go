@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"public_exporter/collector"
+	"public_exporter/config"
+	"strconv"
+	"strings"
+)
+
+// runReport is the JSON output of the run command
+type runReport struct {
+	Cluster         string                  `json:"cluster"`
+	Collector       string                  `json:"collector"`
+	DurationSeconds float64                 `json:"duration_seconds"`
+	ExitCode        int                     `json:"exit_code"`
+	Error           string                  `json:"error,omitempty"`
+	Stderr          string                  `json:"stderr,omitempty"`
+	StderrTruncated bool                    `json:"stderr_truncated,omitempty"`
+	InvalidLines    []*collector.ParseError `json:"invalid_lines,omitempty"`
+	Families        []runFamily             `json:"families"`
+}
+
+type runFamily struct {
+	Name    string      `json:"name"`
+	Help    string      `json:"help,omitempty"`
+	Type    string      `json:"type"`
+	Samples []runSample `json:"samples"`
+}
+
+type runSample struct {
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Value     string            `json:"value"` // a string, as values may be NaN or ±Inf
+	Timestamp int64             `json:"timestamp_ms,omitempty"`
+}
+
+// runCollectorCommand runs a single collector, or a script given on the
+// command line, once and prints what the exporter would publish for it.
+func runCollectorCommand(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config.file", configPath, "Path to configuration file")
+	clusterName := fs.String("cluster", "", "Cluster of the collector to run")
+	collectorName := fs.String("collector", "", "Collector to run")
+	scriptPath := fs.String("script", "", "Script to run instead of a configured collector")
+	scriptType := fs.String("type", "shell", "Script type of -script")
+	interpreter := fs.String("interpreter", "", "Interpreter command line of -script for -type custom")
+	timeout := fs.Int("timeout", 0, "Timeout of -script in seconds (default: global default_timeout)")
+	format := fs.String("format", "text", "Output format: text (exposition format) or json")
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s run -cluster NAME -collector NAME [flags] [-- script args]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s run -script PATH [-type TYPE] [flags] [-- script args]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Runs a collector once and prints the metrics the exporter would publish.")
+		fmt.Fprintln(out, "Script arguments replace the collector's args.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unsupported format %q, use text or json\n", *format)
+		return 2
+	}
+
+	var cfg *config.Config
+	var collectorCfg config.CollectorConfig
+	if *scriptPath != "" {
+		// The configuration is only needed for interpreters and global settings
+		cfg = config.DefaultConfig()
+		if flagSet(fs, "config.file") || flagSet(flag.CommandLine, "config.file") {
+			loaded, err := config.LoadConfig(configPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+				return 1
+			}
+			cfg = loaded
+		}
+		if *clusterName == "" {
+			*clusterName = "cli"
+		}
+		if *collectorName == "" {
+			*collectorName = strings.TrimSuffix(filepath.Base(*scriptPath), filepath.Ext(*scriptPath))
+		}
+		inheritEnv, injectLabels := true, false
+		collectorCfg = config.CollectorConfig{
+			Enabled:       true,
+			Timeout:       cfg.Global.DefaultTimeout,
+			ScriptPath:    *scriptPath,
+			ScriptType:    *scriptType,
+			Interpreter:   *interpreter,
+			InheritEnv:    &inheritEnv,
+			InjectLabels:  &injectLabels,
+			LabelConflict: config.LabelConflictExported,
+		}
+		if *timeout > 0 {
+			collectorCfg.Timeout = *timeout
+		}
+	} else {
+		if *clusterName == "" || *collectorName == "" {
+			fmt.Fprintln(os.Stderr, "Either -cluster and -collector, or -script is required")
+			fs.Usage()
+			return 2
+		}
+		loaded, err := config.LoadConfig(configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			return 1
+		}
+		cfg = loaded
+		var ok bool
+		collectorCfg, ok = cfg.Clusters[*clusterName].Collectors[*collectorName]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Collector %s not found in cluster %s\n", *collectorName, *clusterName)
+			return 1
+		}
+	}
+	if fs.NArg() > 0 {
+		collectorCfg.Args = fs.Args()
+	}
+
+	result, output, invalid := collector.RunOnce(cfg, *clusterName, *collectorName, collectorCfg)
+
+	if *format == "json" {
+		report := runReport{
+			Cluster:         *clusterName,
+			Collector:       *collectorName,
+			DurationSeconds: result.Duration.Seconds(),
+			ExitCode:        result.ExitCode,
+			Stderr:          result.Stderr,
+			StderrTruncated: result.StderrTruncated,
+			InvalidLines:    invalid,
+			Families:        []runFamily{},
+		}
+		if output.Error != nil {
+			report.Error = output.Error.Error()
+		}
+		for _, family := range output.Families {
+			report.Families = append(report.Families, newRunFamily(family))
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		if output.Error == nil {
+			fmt.Print(output.Output)
+		}
+		// The report goes to stderr, so that stdout is exactly the exposition
+		fmt.Fprintf(os.Stderr, "# duration: %.3fs, exit code: %d\n", result.Duration.Seconds(), result.ExitCode)
+		if output.Error != nil {
+			fmt.Fprintf(os.Stderr, "# error: %v\n", output.Error)
+		}
+		for _, parseErr := range invalid {
+			fmt.Fprintf(os.Stderr, "# dropped: %v\n", parseErr)
+		}
+		if result.Stderr != "" {
+			fmt.Fprintf(os.Stderr, "# stderr:\n%s", result.Stderr)
+			if !strings.HasSuffix(result.Stderr, "\n") {
+				fmt.Fprintln(os.Stderr)
+			}
+			if result.StderrTruncated {
+				fmt.Fprintln(os.Stderr, "# stderr truncated")
+			}
+		}
+	}
+
+	if output.Error != nil || len(invalid) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func newRunFamily(family *collector.MetricFamily) runFamily {
+	f := runFamily{
+		Name:    family.Name,
+		Help:    family.Help,
+		Type:    string(family.Type),
+		Samples: make([]runSample, 0, len(family.Samples)),
+	}
+	for _, sample := range family.Samples {
+		s := runSample{
+			Name:      sample.Name,
+			Value:     strconv.FormatFloat(sample.Value, 'g', -1, 64),
+			Timestamp: sample.Timestamp,
+		}
+		if len(sample.Labels) > 0 {
+			s.Labels = make(map[string]string, len(sample.Labels))
+			for _, label := range sample.Labels {
+				s.Labels[label.Name] = label.Value
+			}
+		}
+		f.Samples = append(f.Samples, s)
+	}
+	return f
+}
+
+// flagSet reports whether a flag was given on the command line
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"public_exporter/config"
+)
+
+// schemaCommand prints the JSON Schema of the configuration file
+func schemaCommand(args []string) int {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	output := fs.String("output", "", "File to write the schema to (default: stdout)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s schema [flags]\n\nPrints the JSON Schema of the configuration file.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	data, err := json.MarshalIndent(config.JSONSchema(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating schema: %v\n", err)
+		return 1
+	}
+	data = append(data, '\n')
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing schema: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file implements the circuit breaker of a collector. After a number of
+// consecutive failed runs the breaker opens, and the script is only run now
+// and then to probe whether it works again.
+
+package collector
+
+import (
+	"public_exporter/config"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"    // the collector runs as scheduled
+	CircuitOpen     = "open"      // runs are skipped until the next probe
+	CircuitHalfOpen = "half_open" // a probe is running
+)
+
+// CircuitStates are all breaker states, in the order they are exported
+var CircuitStates = []string{CircuitClosed, CircuitOpen, CircuitHalfOpen}
+
+// breaker tracks the consecutive failures of a collector
+type breaker struct {
+	threshold     int // consecutive failures that open the breaker, 0 to never open
+	probeInterval time.Duration
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	lastProbe time.Time // when the breaker opened or was last probed
+}
+
+func newBreaker(cfg config.CollectorConfig) *breaker {
+	return &breaker{
+		threshold:     cfg.BreakerThreshold,
+		probeInterval: time.Duration(cfg.BreakerProbeInterval) * time.Second,
+		state:         CircuitClosed,
+	}
+}
+
+// allow reports whether a run may start at now, and whether it is a probe.
+// A forced run is a probe as soon as the breaker is open.
+func (b *breaker) allow(now time.Time, force bool) (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if !force && now.Sub(b.lastProbe) < b.probeInterval {
+			return false, false
+		}
+		b.state = CircuitHalfOpen
+		b.lastProbe = now
+		return true, true
+	case CircuitHalfOpen:
+		return false, false // a probe is already running
+	}
+	return true, false
+}
+
+// record counts the outcome of a run and returns the new state if it changed
+func (b *breaker) record(success bool, now time.Time) (changed string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	previous := b.state
+	if success {
+		b.failures = 0
+		b.state = CircuitClosed
+	} else {
+		b.failures++
+		if b.state == CircuitHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+			b.state = CircuitOpen
+		}
+		// Probes are timed from when the breaker opened or the last probe
+		// started, so a probe's duration doesn't delay the next one
+		if previous == CircuitClosed && b.state == CircuitOpen {
+			b.lastProbe = now
+		}
+	}
+	if b.state == previous {
+		return ""
+	}
+	return b.state
+}
+
+// cancel ends a probe that did not run, e.g. because the collector was
+// stopped, leaving the breaker open
+func (b *breaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitHalfOpen {
+		b.state = CircuitOpen
+	}
+}
+
+// status returns the state of the breaker and the consecutive failures
+func (b *breaker) status() (string, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.failures
+}
@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"public_exporter/config"
+	"testing"
+	"time"
+)
+
+func newTestBreaker(threshold int) *breaker {
+	return newBreaker(config.CollectorConfig{BreakerThreshold: threshold, BreakerProbeInterval: 60})
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b := newTestBreaker(3)
+	start := time.Now()
+	for i := 1; i <= 2; i++ {
+		if changed := b.record(false, start); changed != "" {
+			t.Fatalf("failure %d changed the state to %s", i, changed)
+		}
+	}
+	if changed := b.record(false, start); changed != CircuitOpen {
+		t.Fatalf("third failure changed the state to %q, want %s", changed, CircuitOpen)
+	}
+	if state, failures := b.status(); state != CircuitOpen || failures != 3 {
+		t.Errorf("status() = %s, %d, want %s, 3", state, failures, CircuitOpen)
+	}
+	if ok, _ := b.allow(start.Add(59*time.Second), false); ok {
+		t.Error("a run was allowed before the probe interval passed")
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b := newTestBreaker(2)
+	now := time.Now()
+	b.record(false, now)
+	b.record(true, now)
+	if changed := b.record(false, now); changed != "" {
+		t.Errorf("a failure after a success changed the state to %s", changed)
+	}
+}
+
+func TestBreakerNeverOpensWithoutThreshold(t *testing.T) {
+	b := newTestBreaker(0)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		b.record(false, now)
+	}
+	if ok, probe := b.allow(now, false); !ok || probe {
+		t.Errorf("allow() = %v, %v, want a regular run", ok, probe)
+	}
+}
+
+func TestBreakerProbes(t *testing.T) {
+	b := newTestBreaker(1)
+	opened := time.Now()
+	b.record(false, opened)
+
+	// The first probe starts one probe interval after the breaker opened
+	probeStart := opened.Add(time.Minute)
+	if ok, probe := b.allow(probeStart, false); !ok || !probe {
+		t.Fatalf("allow() = %v, %v, want a probe", ok, probe)
+	}
+	if ok, _ := b.allow(probeStart, true); ok {
+		t.Error("a run was allowed while a probe is running")
+	}
+
+	// A failed probe that took 20 seconds doesn't delay the next one
+	if changed := b.record(false, probeStart.Add(20*time.Second)); changed != CircuitOpen {
+		t.Errorf("failed probe changed the state to %q, want %s", changed, CircuitOpen)
+	}
+	if ok, probe := b.allow(probeStart.Add(time.Minute), false); !ok || !probe {
+		t.Fatalf("allow() one interval after the last probe started = %v, %v, want a probe", ok, probe)
+	}
+
+	if changed := b.record(true, probeStart.Add(61*time.Second)); changed != CircuitClosed {
+		t.Errorf("successful probe changed the state to %q, want %s", changed, CircuitClosed)
+	}
+	if state, failures := b.status(); state != CircuitClosed || failures != 0 {
+		t.Errorf("status() = %s, %d, want %s, 0", state, failures, CircuitClosed)
+	}
+}
+
+func TestBreakerForcedRunProbes(t *testing.T) {
+	b := newTestBreaker(1)
+	now := time.Now()
+	b.record(false, now)
+	if ok, probe := b.allow(now, true); !ok || !probe {
+		t.Errorf("allow() of a forced run = %v, %v, want a probe", ok, probe)
+	}
+}
+
+func TestBreakerCancelKeepsItOpen(t *testing.T) {
+	b := newTestBreaker(1)
+	opened := time.Now()
+	b.record(false, opened)
+	probeStart := opened.Add(time.Minute)
+	b.allow(probeStart, false)
+	b.cancel()
+
+	if state, _ := b.status(); state != CircuitOpen {
+		t.Errorf("state after cancel = %s, want %s", state, CircuitOpen)
+	}
+	if ok, _ := b.allow(probeStart.Add(time.Second), false); ok {
+		t.Error("a run was allowed right after a cancelled probe")
+	}
+}
@@ -12,19 +12,48 @@ package collector
 import (
 	"context"
 	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 	"log"
-	"os/exec"
 	"public_exporter/config"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// maxRecordedInvalidLines caps the invalid lines kept per collector run.
+const maxRecordedInvalidLines = 100
+
 // CollectorOutput represents the output of a collector execution
 type CollectorOutput struct {
-	Output   string
-	ExecTime string
-	LastSeen time.Time
-	Error    error
+	Output          string
+	Families        []*MetricFamily
+	InvalidLines    []*ParseError // lines dropped from the output, with the reason
+	Stderr          string
+	StderrTruncated bool
+	ExecTime        string
+	LastSeen        time.Time
+	Error           error
+	Failure         string // why the run failed, see FailureReasons; empty if it succeeded
+}
+
+// CollectorState is a snapshot of a collector's last execution, as shown by
+// the HTTP API
+type CollectorState struct {
+	Cluster         string        `json:"cluster"`
+	Collector       string        `json:"collector"`
+	Healthy         bool          `json:"healthy"`
+	ExecTime        string        `json:"exec_time"`
+	LastSeen        time.Time     `json:"last_seen"`
+	Error           string        `json:"error,omitempty"`
+	Stderr          string        `json:"stderr,omitempty"`
+	StderrTruncated bool          `json:"stderr_truncated,omitempty"`
+	InvalidLines    []*ParseError `json:"invalid_lines,omitempty"`
+	Circuit         string        `json:"circuit"` // closed, open or half_open
+	Failures        int           `json:"consecutive_failures"`
 }
 
 // CollectorManager manages all data collectors
@@ -33,64 +62,168 @@ type CollectorManager struct {
 	ScriptExecutor *ScriptExecutor
 	outputs        sync.Map // key: "cluster:collector" -> *CollectorOutput
 	health         sync.Map // key: "cluster:collector" -> int (1 or 0)
+	invalidLines   sync.Map // key: "cluster:collector" -> *uint64
+	queueWait      sync.Map // key: "cluster:collector" -> float64 (seconds)
+	orphanKills    sync.Map // key: "cluster:collector" -> *uint64
+	running        sync.Map // key: "cluster:collector" -> *sync.Mutex
+	nextRuns       sync.Map // key: "cluster:collector" -> time.Time
+	retries        sync.Map // key: "cluster:collector" -> *uint64
+	breakers       sync.Map // key: "cluster:collector" -> *breaker
+	stats          sync.Map // key: "cluster:collector" -> *executionStats
+	conflicts      sync.Map // key: "cluster:collector" -> int, series skipped at the last scrape
+	runners        map[string]*collectorRunner // key: "cluster:collector"
+	published      atomic.Value                // copy of runners for the scrape path, which must not wait for cm.mu
+	pool           *workerPool
 	ctx            context.Context
 	cancel         context.CancelFunc
 	wg             sync.WaitGroup
-	mu             sync.RWMutex
+	mu             sync.RWMutex     // serializes Start, Reload and Stop
+	cfgMu          sync.RWMutex     // guards Config, ScriptExecutor and pool
+	now            func() time.Time // the clock output ages are measured with
 }
 
-// ScriptExecutor handles script execution with proper timeout and error handling
-type ScriptExecutor struct {
-	mu sync.Mutex
+// collectorRunner is the goroutine running one collector
+type collectorRunner struct {
+	clusterName   string
+	collectorName string
+	cfg           config.CollectorConfig
+	cancel        context.CancelFunc
+	done          chan struct{}
+	trigger       chan struct{}      // requests an immediate execution
+	scrapes       chan scrapeRequest // executions of an on_scrape collector
+	flight        singleflight.Group // shares one execution among concurrent scrapes
+	splay         bool               // global.splay when the runner was created
+	startDelay    time.Duration      // delay of the first run, at startup
+	previous      <-chan struct{}    // done of the runner this one replaces
+	breaker       *breaker
 }
 
-func (se *ScriptExecutor) ExecuteScript(scriptPath, scriptType string, timeout int) (string, string, error) {
-	se.mu.Lock()
-	defer se.mu.Unlock()
-
-	execTime := time.Now().Format("2006-01-02 15:04:05.000")
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-	defer cancel()
-
-	var cmd *exec.Cmd
-	switch scriptType {
-	case "python", "python3":
-		cmd = exec.CommandContext(ctx, "python3", scriptPath)
-	case "python2":
-		cmd = exec.CommandContext(ctx, "python2", scriptPath)
-	case "shell":
-		cmd = exec.CommandContext(ctx, "bash", scriptPath)
-	default:
-		return "", "", fmt.Errorf("unsupported script type: %s", scriptType)
-	}
-
-	output, err := cmd.CombinedOutput()
-	if ctx.Err() == context.DeadlineExceeded {
-		return "", "", fmt.Errorf("script execution timed out after %d seconds", timeout)
-	}
-	if err != nil {
-		return "", "", fmt.Errorf("script execution failed: %v, output: %s", err, string(output))
-	}
-
-	return string(output), execTime, nil
+// scrapeRequest asks the runner of an on_scrape collector for a fresh result
+type scrapeRequest struct {
+	deadline time.Time // zero if the scrape has no timeout
+	done     chan struct{}
 }
 
 func NewCollectorManager(cfg *config.Config) *CollectorManager {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &CollectorManager{
 		Config:         cfg,
-		ScriptExecutor: &ScriptExecutor{},
+		ScriptExecutor: newScriptExecutor(cfg),
+		runners:        make(map[string]*collectorRunner),
+		pool:           newWorkerPool(cfg),
 		ctx:            ctx,
 		cancel:         cancel,
+		now:            time.Now,
+	}
+}
+
+func newScriptExecutor(cfg *config.Config) *ScriptExecutor {
+	return &ScriptExecutor{
+		KillGracePeriod: time.Duration(cfg.Global.KillGracePeriod) * time.Second,
+		MaxStderrBytes:  cfg.Global.MaxStderrBytes,
 	}
 }
 
+// snapshot returns the current configuration, executor and worker pool
+func (cm *CollectorManager) snapshot() (*config.Config, *ScriptExecutor, *workerPool) {
+	cm.cfgMu.RLock()
+	defer cm.cfgMu.RUnlock()
+	return cm.Config, cm.ScriptExecutor, cm.pool
+}
+
 // Start starts all enabled collectors
 func (cm *CollectorManager) Start() error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	for clusterName, clusterCfg := range cm.Config.Clusters {
+	cfg, _, _ := cm.snapshot()
+	spreadOver := time.Duration(cfg.Global.StartupSpread) * time.Second
+	for key, runner := range cm.enabledCollectors(cfg) {
+		// The first runs are staggered so the scripts don't all start at once
+		runner.startDelay = spread(runner.clusterName, runner.collectorName, spreadOver)
+		cm.startRunner(key, runner)
+	}
+	
+	return nil
+}
+
+// Reload applies a new configuration. Collectors that were added are started,
+// removed ones are stopped and forgotten, and changed ones are restarted.
+// Unchanged collectors keep running and keep their last output. A stopped
+// manager ignores reloads.
+func (cm *CollectorManager) Reload(newCfg *config.Config) {
+	cm.mu.Lock()
+	if cm.ctx.Err() != nil {
+		cm.mu.Unlock()
+		return
+	}
+
+	cm.cfgMu.Lock()
+	cm.Config = newCfg
+	cm.ScriptExecutor = newScriptExecutor(newCfg)
+	if !cm.pool.hasLimits(newCfg) {
+		// Scripts already running release their slots into the old pool
+		cm.pool = newWorkerPool(newCfg)
+	}
+	cm.cfgMu.Unlock()
+
+	desired := cm.enabledCollectors(newCfg)
+	removed := make(map[string]*collectorRunner)
+	for key, runner := range cm.runners {
+		newRunner, ok := desired[key]
+		switch {
+		case !ok:
+			log.Printf("Collector %s was removed, stopping it", key)
+			cm.stopRunner(key, runner)
+			removed[key] = runner
+		case !reflect.DeepEqual(runner.cfg, newRunner.cfg) || runner.splay != newRunner.splay:
+			log.Printf("Collector %s was changed, restarting it", key)
+			cm.stopRunner(key, runner)
+			newRunner.previous = runner.done
+			cm.startRunner(key, newRunner)
+		}
+	}
+	for key, runner := range desired {
+		if _, ok := cm.runners[key]; !ok {
+			log.Printf("Collector %s was added, starting it", key)
+			cm.startRunner(key, runner)
+		}
+	}
+	cm.mu.Unlock()
+
+	// Removed collectors are forgotten once their scripts have been
+	// terminated, unless they were added again in the meantime
+	for _, runner := range removed {
+		<-runner.done
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for key := range removed {
+		if _, ok := cm.runners[key]; !ok {
+			cm.forget(key)
+		}
+	}
+}
+
+// Stop gracefully stops all collectors
+func (cm *CollectorManager) Stop() {
+	cm.mu.Lock()
+	log.Println("Stopping all collectors...")
+	cm.cancel()
+	cm.runners = make(map[string]*collectorRunner)
+	cm.publishRunners()
+	cm.mu.Unlock()
+	
+	// Running scripts are terminated, within the kill grace period
+	cm.wg.Wait()
+	log.Println("All collectors stopped")
+}
+
+// enabledCollectors returns runners for all enabled and valid collectors of a
+// configuration, keyed by "cluster:collector"
+func (cm *CollectorManager) enabledCollectors(cfg *config.Config) map[string]*collectorRunner {
+	runners := make(map[string]*collectorRunner)
+	for clusterName, clusterCfg := range cfg.Clusters {
 		if !clusterCfg.Enabled {
 			log.Printf("Cluster %s is disabled, skipping...", clusterName)
 			continue
@@ -108,23 +241,139 @@ func (cm *CollectorManager) Start() error {
 				continue
 			}
 			
-			cm.wg.Add(1)
-			go cm.runCollector(clusterName, collectorName, collectorCfg)
+			key := fmt.Sprintf("%s:%s", clusterName, collectorName)
+			runners[key] = &collectorRunner{
+				clusterName:   clusterName,
+				collectorName: collectorName,
+				cfg:           collectorCfg,
+				splay:         cfg.Global.Splay,
+			}
 		}
 	}
-	
-	return nil
+	return runners
 }
 
-// Stop gracefully stops all collectors
-func (cm *CollectorManager) Stop() {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-	
-	log.Println("Stopping all collectors...")
-	cm.cancel()
-	cm.wg.Wait()
-	log.Println("All collectors stopped")
+// startRunner starts the goroutine of a collector; cm.mu must be held
+func (cm *CollectorManager) startRunner(key string, runner *collectorRunner) {
+	ctx, cancel := context.WithCancel(cm.ctx)
+	runner.cancel = cancel
+	runner.done = make(chan struct{})
+	runner.trigger = make(chan struct{}, 1)
+	runner.scrapes = make(chan scrapeRequest)
+	runner.breaker = newBreaker(runner.cfg)
+	cm.runners[key] = runner
+	cm.publishRunners()
+	cm.breakers.Store(key, runner.breaker)
+
+	cm.wg.Add(1)
+	go cm.runCollector(ctx, runner)
+}
+
+// stopRunner stops the goroutine of a collector, terminating its current
+// execution; runner.done is closed once it has ended. cm.mu must be held.
+func (cm *CollectorManager) stopRunner(key string, runner *collectorRunner) {
+	runner.cancel()
+	delete(cm.runners, key)
+	cm.publishRunners()
+}
+
+// publishRunners makes the current runners visible to activeRunners; cm.mu
+// must be held
+func (cm *CollectorManager) publishRunners() {
+	runners := make(map[string]*collectorRunner, len(cm.runners))
+	for key, runner := range cm.runners {
+		runners[key] = runner
+	}
+	cm.published.Store(runners)
+}
+
+// activeRunners returns the running collectors without taking cm.mu, so that
+// scrapes don't wait for a reload
+func (cm *CollectorManager) activeRunners() map[string]*collectorRunner {
+	runners, _ := cm.published.Load().(map[string]*collectorRunner)
+	return runners
+}
+
+// TriggerScript requests an immediate execution of every running collector
+// whose script is the given file, and returns their keys
+func (cm *CollectorManager) TriggerScript(scriptFile string) []string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	var keys []string
+	for key, runner := range cm.runners {
+		if runner.cfg.ScriptFile() != scriptFile {
+			continue
+		}
+		select {
+		case runner.trigger <- struct{}{}:
+		default: // an execution is already pending
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CollectOnScrape runs the on_scrape collectors for a scrape of /metrics and
+// returns when their results are up to date or ctx is done. Concurrent scrapes
+// share one execution per collector, whose script gets until the deadline of
+// ctx at most; results younger than min_interval are reused.
+func (cm *CollectorManager) CollectOnScrape(ctx context.Context) {
+	var runners []*collectorRunner
+	for _, runner := range cm.activeRunners() {
+		if runner.cfg.Mode == config.ModeOnScrape {
+			runners = append(runners, runner)
+		}
+	}
+
+	deadline, _ := ctx.Deadline()
+	var wg sync.WaitGroup
+	for _, runner := range runners {
+		wg.Add(1)
+		go func(runner *collectorRunner) {
+			defer wg.Done()
+			result := runner.flight.DoChan("scrape", func() (interface{}, error) {
+				runner.scrape(deadline)
+				return nil, nil
+			})
+			select {
+			case <-result:
+			case <-ctx.Done(): // the execution goes on for later scrapes
+			}
+		}(runner)
+	}
+	wg.Wait()
+}
+
+// scrape hands a scrape to the runner and waits for its execution, unless the
+// runner is stopped first
+func (runner *collectorRunner) scrape(deadline time.Time) {
+	req := scrapeRequest{deadline: deadline, done: make(chan struct{})}
+	select {
+	case runner.scrapes <- req:
+	case <-runner.done:
+		return
+	}
+	select {
+	case <-req.done:
+	case <-runner.done:
+	}
+}
+
+// forget drops all state kept for a collector
+func (cm *CollectorManager) forget(key string) {
+	cm.outputs.Delete(key)
+	cm.health.Delete(key)
+	cm.invalidLines.Delete(key)
+	cm.queueWait.Delete(key)
+	cm.orphanKills.Delete(key)
+	cm.running.Delete(key)
+	cm.nextRuns.Delete(key)
+	cm.retries.Delete(key)
+	cm.breakers.Delete(key)
+	cm.stats.Delete(key)
+	cm.conflicts.Delete(key)
 }
 
 // validateCollectorConfig validates collector configuration
@@ -135,76 +384,460 @@ func (cm *CollectorManager) validateCollectorConfig(cfg config.CollectorConfig)
 	if cfg.Timeout <= 0 {
 		return fmt.Errorf("timeout must be positive, got %d", cfg.Timeout)
 	}
-	if cfg.ScriptPath == "" {
-		return fmt.Errorf("script_path cannot be empty")
+	if cfg.ScriptPath == "" && cfg.Inline == "" {
+		return fmt.Errorf("script_path or inline is required")
 	}
 	if cfg.ScriptType == "" {
 		return fmt.Errorf("script_type cannot be empty")
 	}
+	if _, err := newSchedule(cfg); err != nil {
+		return fmt.Errorf("invalid schedule: %v", err)
+	}
 	return nil
 }
 
-func (cm *CollectorManager) runCollector(clusterName, collectorName string, collectorCfg config.CollectorConfig) {
+func (cm *CollectorManager) runCollector(ctx context.Context, runner *collectorRunner) {
 	defer cm.wg.Done()
+	defer close(runner.done)
 	
-	ticker := time.NewTicker(time.Duration(collectorCfg.Interval) * time.Second)
-	defer ticker.Stop()
-
-	log.Printf("Starting collector %s in cluster %s with interval %ds", collectorName, clusterName, collectorCfg.Interval)
+	// A restarted collector waits until the script of its previous runner
+	// has been terminated
+	if runner.previous != nil {
+		select {
+		case <-runner.previous:
+		case <-ctx.Done():
+			return
+		}
+	}
+	
+	clusterName, collectorName, collectorCfg := runner.clusterName, runner.collectorName, runner.cfg
 	key := fmt.Sprintf("%s:%s", clusterName, collectorName)
+	if collectorCfg.Mode == config.ModeOnScrape {
+		cm.serveScrapes(ctx, key, runner)
+		return
+	}
+
+	sched, _ := newSchedule(collectorCfg) // checked by validateCollectorConfig
+	if runner.splay {
+		sched.splay(clusterName, collectorName)
+	}
+	defer cm.nextRuns.Delete(key)
+
+	if sched.cron != nil {
+		log.Printf("Starting collector %s in cluster %s with schedule %q", collectorName, clusterName, collectorCfg.Schedule)
+	} else {
+		log.Printf("Starting collector %s in cluster %s with interval %ds", collectorName, clusterName, collectorCfg.Interval)
+		// Execute once right away, or after the startup delay
+		if runner.startDelay > 0 {
+			timer := time.NewTimer(runner.startDelay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				log.Printf("Collector %s in cluster %s stopped", collectorName, clusterName)
+				return
+			}
+		}
+		if now := time.Now(); sched.active(now) {
+			cm.runCycle(ctx, key, runner, sched.next(now), false)
+		}
+	}
+
+	next := sched.next(time.Now())
+	for {
+		var timer *time.Timer
+		var due <-chan time.Time
+		if next.IsZero() {
+			log.Printf("Collector %s in cluster %s is never scheduled within its active windows", collectorName, clusterName)
+			cm.nextRuns.Delete(key)
+		} else {
+			at := next.Add(sched.delay())
+			cm.nextRuns.Store(key, at)
+			timer = time.NewTimer(time.Until(at))
+			due = timer.C
+		}
 
-	// Execute once immediately
-	cm.executeCollector(key, collectorName, collectorCfg)
+		select {
+		case <-due:
+			cm.runCycle(ctx, key, runner, sched.next(next), false)
+			// Runs missed while the script was still running are skipped
+			next = sched.next(next)
+			if now := time.Now(); next.Before(now) {
+				next = sched.next(now)
+			}
+		case <-runner.trigger:
+			if sched.active(time.Now()) {
+				cm.runCycle(ctx, key, runner, next, true)
+			}
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			log.Printf("Collector %s in cluster %s stopped", collectorName, clusterName)
+			return
+		}
+	}
+}
 
+// serveScrapes runs an on_scrape collector whenever a scrape asks for it and
+// its last result is older than min_interval. A trigger makes the next scrape
+// run it regardless.
+func (cm *CollectorManager) serveScrapes(ctx context.Context, key string, runner *collectorRunner) {
+	clusterName, collectorName, collectorCfg := runner.clusterName, runner.collectorName, runner.cfg
+	minInterval := time.Duration(collectorCfg.MinInterval) * time.Second
+	sched, _ := newSchedule(collectorCfg) // checked by validateCollectorConfig
+	log.Printf("Starting collector %s in cluster %s on scrape", collectorName, clusterName)
+
+	var lastRun time.Time
+	forced := false
 	for {
 		select {
-		case <-ticker.C:
-			cm.executeCollector(key, collectorName, collectorCfg)
-		case <-cm.ctx.Done():
+		case req := <-runner.scrapes:
+			now := time.Now()
+			if (lastRun.IsZero() || now.Sub(lastRun) >= minInterval) && sched.active(now) {
+				if ok, probe := runner.breaker.allow(now, forced); ok {
+					execCtx, cancel := withDeadline(ctx, req.deadline)
+					output := cm.executeCollector(execCtx, key, clusterName, collectorName, collectorCfg)
+					cancel()
+					// An execution skipped, e.g. for want of a worker before
+					// the scrape deadline, leaves the next scrape to run it
+					if output != nil {
+						lastRun, forced = now, false
+					}
+					cm.recordOutcome(key, runner, output, probe)
+				}
+			}
+			close(req.done)
+		case <-runner.trigger:
+			lastRun, forced = time.Time{}, true
+		case <-ctx.Done():
 			log.Printf("Collector %s in cluster %s stopped", collectorName, clusterName)
 			return
 		}
 	}
 }
 
-func (cm *CollectorManager) executeCollector(key, collectorName string, collectorCfg config.CollectorConfig) {
-	output, execTime, err := cm.ScriptExecutor.ExecuteScript(collectorCfg.ScriptPath, collectorCfg.ScriptType, collectorCfg.Timeout)
+// runCycle runs a scheduled collector once, subject to its circuit breaker.
+// Failed runs are retried with exponential backoff as long as the retry
+// starts before the next scheduled run; probes are not retried. A forced run
+// probes an open breaker right away.
+func (cm *CollectorManager) runCycle(ctx context.Context, key string, runner *collectorRunner, nextRun time.Time, force bool) {
+	clusterName, collectorName, collectorCfg := runner.clusterName, runner.collectorName, runner.cfg
+	ok, probe := runner.breaker.allow(time.Now(), force)
+	if !ok {
+		return
+	}
+
+	output := cm.executeCollector(ctx, key, clusterName, collectorName, collectorCfg)
+	backoff := time.Duration(collectorCfg.RetryBackoff) * time.Second
+	for attempt := 1; !probe && attempt <= collectorCfg.Retries && output != nil && output.Error != nil; attempt++ {
+		if !nextRun.IsZero() && time.Now().Add(backoff).After(nextRun) {
+			break
+		}
+		log.Printf("Retrying collector %s in %v (retry %d of %d)", key, backoff, attempt, collectorCfg.Retries)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			runner.breaker.cancel()
+			return
+		}
+		counter, _ := cm.retries.LoadOrStore(key, new(uint64))
+		atomic.AddUint64(counter.(*uint64), 1)
+		output = cm.executeCollector(ctx, key, clusterName, collectorName, collectorCfg)
+		backoff *= 2
+	}
+	cm.recordOutcome(key, runner, output, probe)
+}
+
+// recordOutcome updates a collector's circuit breaker with the outcome of a
+// run, or of a probe, and logs when the breaker opens or closes
+func (cm *CollectorManager) recordOutcome(key string, runner *collectorRunner, output *CollectorOutput, probe bool) {
+	if output == nil {
+		// Skipped, e.g. because the collector was stopped
+		if probe {
+			runner.breaker.cancel()
+		}
+		return
+	}
+	switch runner.breaker.record(output.Error == nil, time.Now()) {
+	case CircuitOpen:
+		if probe {
+			log.Printf("Probe of collector %s failed, circuit breaker stays open", key)
+		} else {
+			log.Printf("Circuit breaker of collector %s opened after %d consecutive failures, probing every %ds",
+				key, runner.cfg.BreakerThreshold, runner.cfg.BreakerProbeInterval)
+		}
+	case CircuitClosed:
+		log.Printf("Collector %s works again, circuit breaker closed", key)
+	}
+}
+
+// withDeadline returns ctx limited to deadline, unless deadline is zero
+func withDeadline(ctx context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// executeCollector runs a collector's script, stores its output and returns
+// it, or nil if the execution was skipped. If ctx has a deadline, the script
+// is terminated at the deadline at the latest.
+func (cm *CollectorManager) executeCollector(ctx context.Context, key, clusterName, collectorName string, collectorCfg config.CollectorConfig) *CollectorOutput {
+	// A collector never overlaps with itself
+	lock, _ := cm.running.LoadOrStore(key, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		log.Printf("Collector %s is still running, skipping this execution", key)
+		return nil
+	}
+	defer lock.(*sync.Mutex).Unlock()
+
+	cfg, executor, pool := cm.snapshot()
+	queuedAt := time.Now()
+	release, err := pool.acquire(ctx, clusterName)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Printf("Collector %s waited for a worker until the scrape deadline, skipping this execution", key)
+		}
+		return nil // shutting down or out of time
+	}
+	defer release()
+	cm.queueWait.Store(key, time.Since(queuedAt).Seconds())
+
+	result := &ExecResult{ExecTime: time.Now().Format("2006-01-02 15:04:05.000"), ExitCode: -1, Failure: FailureStart}
+	req, err := NewScriptRequest(cfg, clusterName, collectorName, collectorCfg)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < req.Timeout {
+		req.Timeout = time.Until(deadline)
+	}
+	if err == nil {
+		result, err = executor.ExecuteScript(ctx, req)
+	}
+	if ctx.Err() == context.Canceled {
+		// The collector was stopped; its replacement, if any, runs it again
+		log.Printf("Collector %s was stopped while running, discarding this execution", key)
+		return nil
+	}
 	
-	collectorOutput := &CollectorOutput{
-		Output:   output,
-		ExecTime: execTime,
-		LastSeen: time.Now(),
-		Error:    err,
+	orphanKills, _ := cm.orphanKills.LoadOrStore(key, new(uint64))
+	if result.OrphansKilled {
+		log.Printf("Killed processes left behind by script %s of collector %s", collectorCfg.ScriptName(), key)
+		atomic.AddUint64(orphanKills.(*uint64), 1)
 	}
 	
-	if err != nil {
-		log.Printf("Error executing script %s for collector %s: %v", collectorCfg.ScriptPath, collectorName, err)
+	logStderr(cfg, key, result)
+	collectorOutput, invalid := processOutput(result, err, clusterName, collectorName, collectorCfg)
+	cm.recordInvalidLines(key, collectorOutput, invalid)
+	stats, _ := cm.stats.LoadOrStore(key, newExecutionStats())
+	stats.(*executionStats).record(result, collectorOutput)
+	
+	if collectorOutput.Error != nil {
+		log.Printf("Error executing script %s for collector %s: %v", collectorCfg.ScriptName(), collectorName, collectorOutput.Error)
 		cm.health.Store(key, 0)
-		collectorOutput.Output = fmt.Sprintf("Error: %v", err)
 	} else {
-		// Format for Prometheus
-		helpText := fmt.Sprintf(`# HELP %s Metric collected from external script`, collectorName)
-		typeText := fmt.Sprintf(`# TYPE %s gauge`, collectorName)
-		formatted := fmt.Sprintf("%s\n%s\n# Script: %s, exec_time: %s\n%s", 
-			helpText, typeText, collectorCfg.ScriptPath, execTime, output)
-		collectorOutput.Output = formatted
 		cm.health.Store(key, 1)
 	}
 	
 	cm.outputs.Store(key, collectorOutput)
 	log.Printf("Updated output for %s", key)
+	return collectorOutput
+}
+
+// processOutput turns the result of a script execution into the collector
+// output the exporter publishes: the metrics are parsed, and metadata defaults
+// and target labels applied. It also returns the lines dropped as invalid.
+func processOutput(result *ExecResult, err error, clusterName, collectorName string, collectorCfg config.CollectorConfig) (*CollectorOutput, []*ParseError) {
+	collectorOutput := &CollectorOutput{
+		Stderr:          result.Stderr,
+		StderrTruncated: result.StderrTruncated,
+		ExecTime:        result.ExecTime,
+		LastSeen:        time.Now(),
+	}
+	
+	var families []*MetricFamily
+	var invalid []*ParseError
+	if err != nil {
+		collectorOutput.Failure = result.Failure
+	} else {
+		families, invalid, err = ParseMetrics(result.Output)
+		if err != nil {
+			err = fmt.Errorf("invalid script output: %w", err)
+			collectorOutput.Failure = FailureParse
+		}
+		applyMetadataDefaults(families, collectorCfg)
+		labels := targetLabels(clusterName, collectorName, collectorCfg)
+		invalid = append(invalid, injectLabels(families, labels, collectorCfg.LabelConflict)...)
+	}
+	collectorOutput.Error = err
+	
+	if err != nil {
+		collectorOutput.Output = fmt.Sprintf("Error: %v", err)
+	} else {
+		collectorOutput.Output = FormatMetrics(families)
+		collectorOutput.Families = families
+	}
+	return collectorOutput, invalid
+}
+
+// RunOnce executes a collector's script once, outside of any schedule, and
+// returns the raw result, the output as the exporter would publish it and the
+// lines dropped from it. It is meant for checking and developing collectors.
+func RunOnce(cfg *config.Config, clusterName, collectorName string, collectorCfg config.CollectorConfig) (*ExecResult, *CollectorOutput, []*ParseError) {
+	result := &ExecResult{ExecTime: time.Now().Format("2006-01-02 15:04:05.000"), ExitCode: -1, Failure: FailureStart}
+	req, err := NewScriptRequest(cfg, clusterName, collectorName, collectorCfg)
+	if err == nil {
+		result, err = newScriptExecutor(cfg).ExecuteScript(context.Background(), req)
+	}
+	collectorOutput, invalid := processOutput(result, err, clusterName, collectorName, collectorCfg)
+	return result, collectorOutput, invalid
+}
+
+// logStderr logs what a script wrote to standard error at the configured level.
+func logStderr(cfg *config.Config, key string, result *ExecResult) {
+	stderr := strings.TrimSpace(result.Stderr)
+	if stderr == "" {
+		return
+	}
+	level, err := logrus.ParseLevel(cfg.Global.StderrLogLevel)
+	if err != nil {
+		level = logrus.WarnLevel
+	}
+	if result.StderrTruncated {
+		stderr += " [truncated]"
+	}
+	logrus.WithField("collector", key).Logf(level, "Script stderr: %s", stderr)
+}
+
+// recordInvalidLines logs and counts lines dropped from a collector's output
+// and keeps them in the collector state for inspection.
+func (cm *CollectorManager) recordInvalidLines(key string, collectorOutput *CollectorOutput, invalid []*ParseError) {
+	counter, _ := cm.invalidLines.LoadOrStore(key, new(uint64))
+	if len(invalid) == 0 {
+		return
+	}
+	atomic.AddUint64(counter.(*uint64), uint64(len(invalid)))
+
+	for _, parseErr := range invalid {
+		log.Printf("Dropped invalid output of %s: %v", key, parseErr)
+	}
+	if len(invalid) > maxRecordedInvalidLines {
+		invalid = invalid[:maxRecordedInvalidLines]
+	}
+	collectorOutput.InvalidLines = invalid
+}
+
+// GatherFamilies returns the metric families of all collectors, merged by
+// metric name for the metrics endpoint. Output older than its collector's
+// max_age is dropped or labeled stale, depending on stale_action. Series
+// skipped because they conflict with another collector are counted, and
+// logged when their number changes.
+func (cm *CollectorManager) GatherFamilies() []*MetricFamily {
+	configs := cm.collectorConfigs()
+	now := cm.now()
+	sources := make(map[string][]*MetricFamily)
+	var keys []string
+	cm.outputs.Range(func(key, value interface{}) bool {
+		keys = append(keys, key.(string))
+		output, ok := value.(*CollectorOutput)
+		if !ok || output.Error != nil {
+			return true
+		}
+		cfg := configs[key.(string)]
+		switch {
+		case !isStale(cfg, output, now):
+			sources[key.(string)] = output.Families
+		case cfg.StaleAction == config.StaleLabel:
+			sources[key.(string)] = markStale(output.Families)
+		}
+		return true
+	})
+
+	families, skipped := MergeFamilies(sources)
+	for _, key := range keys {
+		count := skipped[key]
+		if previous, _ := cm.conflicts.Swap(key, count); count > 0 && previous != count {
+			log.Printf("Skipping %d series of %s that another collector already exposes, or with another type", count, key)
+		}
+	}
+	return families
+}
+
+// GetOutputAges returns the age of every collector's output and, for
+// collectors with stale_action metric, whether it is stale
+func (cm *CollectorManager) GetOutputAges() (map[string]float64, map[string]bool) {
+	configs := cm.collectorConfigs()
+	now := cm.now()
+	ages := make(map[string]float64)
+	stale := make(map[string]bool)
+	cm.outputs.Range(func(key, value interface{}) bool {
+		output := value.(*CollectorOutput)
+		ages[key.(string)] = now.Sub(output.LastSeen).Seconds()
+		if cfg := configs[key.(string)]; cfg.MaxAge > 0 && cfg.StaleAction == config.StaleMetric {
+			stale[key.(string)] = isStale(cfg, output, now)
+		}
+		return true
+	})
+	return ages, stale
 }
 
-// GetOutputs returns all collector outputs for metrics endpoint
-func (cm *CollectorManager) GetOutputs() []string {
-	var outputs []string
+// isStale reports whether a collector's output is older than its max_age at now
+func isStale(cfg config.CollectorConfig, output *CollectorOutput, now time.Time) bool {
+	return cfg.MaxAge > 0 && now.Sub(output.LastSeen) > time.Duration(cfg.MaxAge)*time.Second
+}
+
+// collectorConfigs returns the configuration of every running collector
+func (cm *CollectorManager) collectorConfigs() map[string]config.CollectorConfig {
+	runners := cm.activeRunners()
+	configs := make(map[string]config.CollectorConfig, len(runners))
+	for key, runner := range runners {
+		configs[key] = runner.cfg
+	}
+	return configs
+}
+
+// GetCollectorStates returns the state of every collector's last execution,
+// sorted by cluster and collector name
+func (cm *CollectorManager) GetCollectorStates() []CollectorState {
+	var states []CollectorState
 	cm.outputs.Range(func(key, value interface{}) bool {
-		if output, ok := value.(*CollectorOutput); ok {
-			outputs = append(outputs, output.Output)
+		output, ok := value.(*CollectorOutput)
+		if !ok {
+			return true
+		}
+		parts := strings.SplitN(key.(string), ":", 2)
+		if len(parts) != 2 {
+			return true
+		}
+		state := CollectorState{
+			Cluster:         parts[0],
+			Collector:       parts[1],
+			Healthy:         output.Error == nil,
+			ExecTime:        output.ExecTime,
+			LastSeen:        output.LastSeen,
+			Stderr:          output.Stderr,
+			StderrTruncated: output.StderrTruncated,
+			InvalidLines:    output.InvalidLines,
+		}
+		if output.Error != nil {
+			state.Error = output.Error.Error()
 		}
+		state.Circuit = CircuitClosed
+		if b, ok := cm.breakers.Load(key); ok {
+			state.Circuit, state.Failures = b.(*breaker).status()
+		}
+		states = append(states, state)
 		return true
 	})
-	return outputs
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Cluster != states[j].Cluster {
+			return states[i].Cluster < states[j].Cluster
+		}
+		return states[i].Collector < states[j].Collector
+	})
+	return states
 }
 
 // GetHealthStatus returns health status for all collectors
@@ -219,6 +852,102 @@ func (cm *CollectorManager) GetHealthStatus() map[string]int {
 	return status
 }
 
+// GetInvalidLineCounts returns the total number of invalid output lines
+// dropped per collector
+func (cm *CollectorManager) GetInvalidLineCounts() map[string]uint64 {
+	counts := make(map[string]uint64)
+	cm.invalidLines.Range(func(key, value interface{}) bool {
+		counts[key.(string)] = atomic.LoadUint64(value.(*uint64))
+		return true
+	})
+	return counts
+}
+
+// GetConflictCounts returns the number of series of each collector skipped at
+// the last scrape because another collector exposes them, or their metric with
+// another type
+func (cm *CollectorManager) GetConflictCounts() map[string]int {
+	counts := make(map[string]int)
+	cm.conflicts.Range(func(key, value interface{}) bool {
+		counts[key.(string)] = value.(int)
+		return true
+	})
+	return counts
+}
+
+// GetOrphanKillCounts returns how often processes left behind by a script had
+// to be killed, per collector
+func (cm *CollectorManager) GetOrphanKillCounts() map[string]uint64 {
+	counts := make(map[string]uint64)
+	cm.orphanKills.Range(func(key, value interface{}) bool {
+		counts[key.(string)] = atomic.LoadUint64(value.(*uint64))
+		return true
+	})
+	return counts
+}
+
+// GetQueueWaitSeconds returns how long each collector's last execution waited
+// for a free worker
+func (cm *CollectorManager) GetQueueWaitSeconds() map[string]float64 {
+	waits := make(map[string]float64)
+	cm.queueWait.Range(func(key, value interface{}) bool {
+		waits[key.(string)] = value.(float64)
+		return true
+	})
+	return waits
+}
+
+// GetCircuitStates returns the circuit breaker state of every collector and
+// its number of consecutive failures
+func (cm *CollectorManager) GetCircuitStates() (map[string]string, map[string]int) {
+	states := make(map[string]string)
+	failures := make(map[string]int)
+	cm.breakers.Range(func(key, value interface{}) bool {
+		states[key.(string)], failures[key.(string)] = value.(*breaker).status()
+		return true
+	})
+	return states, failures
+}
+
+// GetExecutionStats returns the execution statistics of every collector that
+// ran at least once
+func (cm *CollectorManager) GetExecutionStats() map[string]ExecutionStats {
+	stats := make(map[string]ExecutionStats)
+	cm.stats.Range(func(key, value interface{}) bool {
+		stats[key.(string)] = value.(*executionStats).snapshot()
+		return true
+	})
+	return stats
+}
+
+// GetRetryCounts returns the total number of retries of failed runs per
+// collector
+func (cm *CollectorManager) GetRetryCounts() map[string]uint64 {
+	counts := make(map[string]uint64)
+	cm.retries.Range(func(key, value interface{}) bool {
+		counts[key.(string)] = atomic.LoadUint64(value.(*uint64))
+		return true
+	})
+	return counts
+}
+
+// GetNextRuns returns when each scheduled collector runs next
+func (cm *CollectorManager) GetNextRuns() map[string]time.Time {
+	nextRuns := make(map[string]time.Time)
+	cm.nextRuns.Range(func(key, value interface{}) bool {
+		nextRuns[key.(string)] = value.(time.Time)
+		return true
+	})
+	return nextRuns
+}
+
+// GetWorkerStats returns the number of scripts waiting for a worker and the
+// number of scripts currently running
+func (cm *CollectorManager) GetWorkerStats() (queued, running int) {
+	_, _, pool := cm.snapshot()
+	return pool.stats()
+}
+
 // GetCollectorCount returns the total number of active collectors
 func (cm *CollectorManager) GetCollectorCount() int {
 	count := 0
@@ -0,0 +1,344 @@
+//go:build !windows
+
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"public_exporter/config"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// managerConfig returns a configuration with one cluster running the given
+// inline scripts, once an hour
+func managerConfig(scripts map[string]string) *config.Config {
+	cfg := config.DefaultConfig()
+	cluster := config.ClusterConfig{Enabled: true, Collectors: make(map[string]config.CollectorConfig)}
+	for name, script := range scripts {
+		cluster.Collectors[name] = config.CollectorConfig{
+			Enabled:     true,
+			Interval:    3600,
+			Timeout:     5,
+			Inline:      script,
+			ScriptType:  "custom",
+			Interpreter: "sh",
+		}
+	}
+	cfg.Clusters = map[string]config.ClusterConfig{"test": cluster}
+	return cfg
+}
+
+// waitForOutput waits until a collector has run at least once
+func waitForOutput(t *testing.T, cm *CollectorManager, key string) *CollectorOutput {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if output, ok := cm.outputs.Load(key); ok {
+			return output.(*CollectorOutput)
+		}
+	}
+	t.Fatalf("collector %s did not run", key)
+	return nil
+}
+
+func TestReloadAppliesDifferences(t *testing.T) {
+	cm := NewCollectorManager(managerConfig(map[string]string{
+		"same":    "echo same 1",
+		"changed": "echo changed 1",
+		"removed": "echo removed 1",
+	}))
+	if err := cm.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cm.Stop()
+	sameOutput := waitForOutput(t, cm, "test:same")
+	waitForOutput(t, cm, "test:changed")
+	waitForOutput(t, cm, "test:removed")
+	before := make(map[string]*collectorRunner)
+	for key, runner := range cm.runners {
+		before[key] = runner
+	}
+
+	cm.Reload(managerConfig(map[string]string{
+		"same":    "echo same 1",
+		"changed": "echo changed 2",
+		"added":   "echo added 1",
+	}))
+
+	cm.mu.RLock()
+	runners := cm.runners
+	cm.mu.RUnlock()
+	if len(runners) != 3 {
+		t.Errorf("%d collectors running, want 3", len(runners))
+	}
+	if runners["test:same"] != before["test:same"] {
+		t.Error("the unchanged collector was restarted")
+	}
+	if runners["test:changed"] == before["test:changed"] {
+		t.Error("the changed collector was not restarted")
+	}
+	if _, ok := runners["test:removed"]; ok {
+		t.Error("the removed collector is still running")
+	}
+	if _, ok := cm.activeRunners()["test:added"]; !ok {
+		t.Error("the added collector was not published")
+	}
+
+	// The removed collector is forgotten; the unchanged one keeps its output
+	if _, ok := cm.outputs.Load("test:removed"); ok {
+		t.Error("the output of the removed collector is still served")
+	}
+	if output, _ := cm.outputs.Load("test:same"); output != sameOutput {
+		t.Error("the unchanged collector lost its output")
+	}
+	waitForOutput(t, cm, "test:added")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		output, _ := cm.outputs.Load("test:changed")
+		if families := output.(*CollectorOutput).Families; len(families) == 1 && families[0].Samples[0].Value == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the changed collector did not run its new script")
+		}
+	}
+}
+
+func TestReloadAfterStop(t *testing.T) {
+	cm := NewCollectorManager(managerConfig(map[string]string{"a": "echo a 1"}))
+	if err := cm.Start(); err != nil {
+		t.Fatal(err)
+	}
+	cm.Stop()
+
+	cm.Reload(managerConfig(map[string]string{"a": "echo a 1", "b": "echo b 1"}))
+	if len(cm.runners) != 0 || len(cm.activeRunners()) != 0 {
+		t.Errorf("a reload after Stop started collectors: %v", cm.runners)
+	}
+}
+
+// onScrapeConfig returns a configuration with the on_scrape collector
+// "scrape", whose script records its runs in dir/runs before running script
+func onScrapeConfig(dir, script string, minInterval int) *config.Config {
+	cfg := managerConfig(map[string]string{
+		"scrape": "echo run >> " + filepath.Join(dir, "runs") + "; " + script,
+	})
+	collectorCfg := cfg.Clusters["test"].Collectors["scrape"]
+	collectorCfg.Mode = config.ModeOnScrape
+	collectorCfg.MinInterval = minInterval
+	cfg.Clusters["test"].Collectors["scrape"] = collectorCfg
+	return cfg
+}
+
+// scriptRuns returns how often the script of onScrapeConfig ran
+func scriptRuns(dir string) int {
+	data, _ := os.ReadFile(filepath.Join(dir, "runs"))
+	return strings.Count(string(data), "run\n")
+}
+
+func startManager(t *testing.T, cfg *config.Config) *CollectorManager {
+	t.Helper()
+	cm := NewCollectorManager(cfg)
+	if err := cm.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cm.Stop)
+	return cm
+}
+
+// sampleValue returns the value of the first sample a collector reported
+func sampleValue(t *testing.T, cm *CollectorManager, key string) float64 {
+	t.Helper()
+	output, ok := cm.outputs.Load(key)
+	if !ok {
+		t.Fatalf("collector %s has no output", key)
+	}
+	families := output.(*CollectorOutput).Families
+	if len(families) == 0 || len(families[0].Samples) == 0 {
+		t.Fatalf("collector %s reported no samples: %+v", key, output)
+	}
+	return families[0].Samples[0].Value
+}
+
+func TestCollectOnScrapeSharesExecutions(t *testing.T) {
+	dir := t.TempDir()
+	cm := startManager(t, onScrapeConfig(dir, "sleep 0.3; echo up 1", 0))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cm.CollectOnScrape(context.Background())
+		}()
+	}
+	wg.Wait()
+	if n := scriptRuns(dir); n != 1 {
+		t.Errorf("concurrent scrapes ran the script %d times, want 1", n)
+	}
+	if value := sampleValue(t, cm, "test:scrape"); value != 1 {
+		t.Errorf("up = %v, want 1", value)
+	}
+}
+
+func TestCollectOnScrapeMinInterval(t *testing.T) {
+	tests := []struct {
+		name        string
+		minInterval int
+		want        int
+	}{
+		{"results are reused within min_interval", 60, 1},
+		{"every scrape runs without min_interval", 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cm := startManager(t, onScrapeConfig(dir, "echo up 1", tt.minInterval))
+			for i := 0; i < 3; i++ {
+				cm.CollectOnScrape(context.Background())
+			}
+			if n := scriptRuns(dir); n != tt.want {
+				t.Errorf("3 scrapes ran the script %d times, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectOnScrapeDeadline(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration // of the scrape, 0 for none
+		want    float64       // PE_TIMEOUT seen by the script
+	}{
+		{"the scrape deadline limits the timeout", 3 * time.Second, 2},
+		{"without a deadline the collector timeout applies", 0, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := startManager(t, onScrapeConfig(t.TempDir(), "echo timeout $PE_TIMEOUT", 0))
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if tt.timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+			}
+			defer cancel()
+			cm.CollectOnScrape(ctx)
+			if value := sampleValue(t, cm, "test:scrape"); value != tt.want {
+				t.Errorf("PE_TIMEOUT = %v, want %v", value, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectOnScrapeTerminatesAtDeadline(t *testing.T) {
+	cm := startManager(t, onScrapeConfig(t.TempDir(), "sleep 10; echo up 1", 0))
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	begin := time.Now()
+	cm.CollectOnScrape(ctx)
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("the scrape took %v, want it to end at its deadline", elapsed)
+	}
+	if output := waitForOutput(t, cm, "test:scrape"); output.Failure != FailureTimeout {
+		t.Errorf("Failure = %q, want %q", output.Failure, FailureTimeout)
+	}
+}
+
+func TestCollectOnScrapeRetriesSkippedExecutions(t *testing.T) {
+	dir := t.TempDir()
+	started := filepath.Join(dir, "started")
+	cfg := onScrapeConfig(dir, "echo up 1", 60)
+	cfg.Global.MaxConcurrentScripts = 1
+	cfg.Clusters["test"].Collectors["busy"] = config.CollectorConfig{
+		Enabled:     true,
+		Interval:    3600,
+		Timeout:     5,
+		Inline:      "touch " + started + "; sleep 1; echo busy 1",
+		ScriptType:  "custom",
+		Interpreter: "sh",
+	}
+	cm := startManager(t, cfg)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(started); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the busy collector did not start")
+		}
+	}
+
+	// The only worker is busy until after the deadline of the first scrape
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	cm.CollectOnScrape(ctx)
+	if n := scriptRuns(dir); n != 0 {
+		t.Fatalf("the script ran %d times without a worker", n)
+	}
+	// Let the skipped execution end, so the next scrape doesn't share it
+	time.Sleep(100 * time.Millisecond)
+
+	// The skipped execution doesn't count towards min_interval
+	cm.CollectOnScrape(context.Background())
+	if n := scriptRuns(dir); n != 1 {
+		t.Errorf("the script ran %d times after the worker was free, want 1", n)
+	}
+	cm.CollectOnScrape(context.Background())
+	if n := scriptRuns(dir); n != 1 {
+		t.Errorf("the script ran %d times within min_interval, want 1", n)
+	}
+}
+
+func TestStaleOutput(t *testing.T) {
+	lastSeen := time.Date(2025, time.April, 4, 12, 0, 0, 0, time.UTC)
+	fresh, stale := lastSeen.Add(30*time.Second), lastSeen.Add(90*time.Second)
+
+	tests := []struct {
+		name      string
+		maxAge    int
+		action    string
+		now       time.Time
+		labels    []Label // of the served sample, nil if it is not served
+		stale     bool    // collector_output_stale
+		hasMetric bool    // whether collector_output_stale is set
+	}{
+		{"fresh output is served", 60, config.StaleDrop, fresh, []Label{}, false, false},
+		{"output exactly max_age old is fresh", 60, config.StaleDrop, lastSeen.Add(60 * time.Second), []Label{}, false, false},
+		{"drop", 60, config.StaleDrop, stale, nil, false, false},
+		{"label", 60, config.StaleLabel, stale, []Label{{"stale", "true"}}, false, false},
+		{"metric while fresh", 60, config.StaleMetric, fresh, []Label{}, false, true},
+		{"metric", 60, config.StaleMetric, stale, nil, true, true},
+		{"max_age 0 never goes stale", 0, config.StaleMetric, lastSeen.Add(24 * time.Hour), []Label{}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := NewCollectorManager(config.DefaultConfig())
+			cm.now = func() time.Time { return tt.now }
+			cm.runners["test:up"] = &collectorRunner{cfg: config.CollectorConfig{MaxAge: tt.maxAge, StaleAction: tt.action}}
+			cm.publishRunners()
+			families := []*MetricFamily{{Name: "up", Type: "gauge", Samples: []Sample{{Name: "up", Labels: []Label{}, Value: 1}}}}
+			cm.outputs.Store("test:up", &CollectorOutput{Families: families, LastSeen: lastSeen})
+
+			served := cm.GatherFamilies()
+			switch {
+			case tt.labels == nil && len(served) != 0:
+				t.Errorf("GatherFamilies() = %+v, want the output dropped", served[0].Samples)
+			case tt.labels != nil && (len(served) != 1 || !reflect.DeepEqual(served[0].Samples[0].Labels, tt.labels)):
+				t.Errorf("GatherFamilies() = %+v, want up with labels %v", served, tt.labels)
+			}
+			if len(families[0].Samples[0].Labels) != 0 {
+				t.Errorf("the stored output was changed: %+v", families[0].Samples)
+			}
+
+			ages, staleness := cm.GetOutputAges()
+			if want := tt.now.Sub(lastSeen).Seconds(); ages["test:up"] != want {
+				t.Errorf("age = %v, want %v", ages["test:up"], want)
+			}
+			if value, ok := staleness["test:up"]; ok != tt.hasMetric || value != tt.stale {
+				t.Errorf("stale = %v (set %v), want %v (set %v)", value, ok, tt.stale, tt.hasMetric)
+			}
+		})
+	}
+}
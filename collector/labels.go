@@ -0,0 +1,133 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file implements label injection for collected series. Depending on the
+// collector configuration, every sample is rewritten to carry the cluster and
+// collector names and any static labels configured for it.
+
+package collector
+
+import (
+	"fmt"
+	"public_exporter/config"
+	"sort"
+	"strings"
+)
+
+// SeriesLabels returns the cluster and collector labels of the exporter's own
+// series about a collector, in exposition format, given its
+// "cluster:collector" key. The collector name may itself contain colons.
+func SeriesLabels(key string) string {
+	cluster, name, _ := strings.Cut(key, ":")
+	return fmt.Sprintf(`cluster="%s", collector="%s"`, escapeLabelValue(cluster), escapeLabelValue(name))
+}
+
+// targetLabels returns the labels the exporter adds to every sample of a
+// collector, sorted by name. Static labels take precedence over the injected
+// cluster and collector labels.
+func targetLabels(clusterName, collectorName string, cfg config.CollectorConfig) []Label {
+	values := make(map[string]string)
+	if cfg.InjectLabels != nil && *cfg.InjectLabels {
+		values["cluster"] = clusterName
+		values["collector"] = collectorName
+	}
+	for name, value := range cfg.Labels {
+		values[name] = value
+	}
+
+	labels := make([]Label, 0, len(values))
+	for name, value := range values {
+		labels = append(labels, Label{Name: name, Value: value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
+
+// injectLabels adds the target labels to every sample of the families,
+// resolving conflicts with labels set by the script according to the conflict
+// mode. Samples that become duplicates of an earlier series are dropped and
+// returned as invalid.
+func injectLabels(families []*MetricFamily, labels []Label, conflict string) []*ParseError {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	var invalid []*ParseError
+	seen := make(map[string]bool)
+	for _, family := range families {
+		samples := family.Samples[:0]
+		for _, sample := range family.Samples {
+			sample.Labels = mergeLabels(sample.Labels, labels, conflict)
+			id := SeriesID(sample)
+			if seen[id] {
+				invalid = append(invalid, &ParseError{
+					Text:   FormatSample(sample),
+					Reason: "duplicate series after label injection",
+				})
+				continue
+			}
+			seen[id] = true
+			samples = append(samples, sample)
+		}
+		family.Samples = samples
+	}
+	return invalid
+}
+
+// markStale returns copies of the families with a stale="true" label on every
+// sample, for output older than its collector's max_age
+func markStale(families []*MetricFamily) []*MetricFamily {
+	stale := []Label{{Name: "stale", Value: "true"}}
+	marked := make([]*MetricFamily, 0, len(families))
+	for _, family := range families {
+		copied := &MetricFamily{Name: family.Name, Help: family.Help, Type: family.Type}
+		for _, sample := range family.Samples {
+			sample.Labels = mergeLabels(sample.Labels, stale, config.LabelConflictOverwrite)
+			copied.Samples = append(copied.Samples, sample)
+		}
+		marked = append(marked, copied)
+	}
+	return marked
+}
+
+// mergeLabels returns the sample labels with the target labels applied.
+func mergeLabels(sampleLabels, targets []Label, conflict string) []Label {
+	merged := make([]Label, 0, len(sampleLabels)+len(targets))
+	merged = append(merged, sampleLabels...)
+
+	for _, target := range targets {
+		idx := labelIndex(merged, target.Name)
+		if idx < 0 {
+			merged = append(merged, target)
+			continue
+		}
+
+		switch conflict {
+		case config.LabelConflictKeep:
+			// The script's value wins
+		case config.LabelConflictOverwrite:
+			merged[idx].Value = target.Value
+		default:
+			// Move the script's value out of the way, as Prometheus does
+			// for conflicting target labels
+			name := "exported_" + target.Name
+			for labelIndex(merged, name) >= 0 {
+				name = "exported_" + name
+			}
+			merged[idx].Name = name
+			merged = append(merged, target)
+		}
+	}
+	return merged
+}
+
+func labelIndex(labels []Label, name string) int {
+	for i, label := range labels {
+		if label.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"public_exporter/config"
+	"reflect"
+	"testing"
+)
+
+func TestMergeLabelsConflicts(t *testing.T) {
+	script := []Label{{"cluster", "script"}, {"exported_cluster", "older"}, {"path", "/"}}
+	targets := []Label{{"cluster", "prod"}, {"collector", "disk"}}
+
+	tests := []struct {
+		conflict string
+		want     []Label
+	}{
+		{config.LabelConflictExported, []Label{
+			{"exported_exported_cluster", "script"}, {"exported_cluster", "older"}, {"path", "/"},
+			{"cluster", "prod"}, {"collector", "disk"},
+		}},
+		{config.LabelConflictOverwrite, []Label{
+			{"cluster", "prod"}, {"exported_cluster", "older"}, {"path", "/"}, {"collector", "disk"},
+		}},
+		{config.LabelConflictKeep, []Label{
+			{"cluster", "script"}, {"exported_cluster", "older"}, {"path", "/"}, {"collector", "disk"},
+		}},
+	}
+	for _, tt := range tests {
+		got := mergeLabels(script, targets, tt.conflict)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: labels = %v, want %v", tt.conflict, got, tt.want)
+		}
+	}
+	if script[0].Name != "cluster" {
+		t.Errorf("the sample labels were modified: %v", script)
+	}
+}
+
+func TestTargetLabels(t *testing.T) {
+	inject := true
+	cfg := config.CollectorConfig{
+		InjectLabels: &inject,
+		Labels:       map[string]string{"collector": "static", "team": "ops"},
+	}
+	want := []Label{{"cluster", "prod"}, {"collector", "static"}, {"team", "ops"}}
+	if got := targetLabels("prod", "disk", cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("labels = %v, want %v", got, want)
+	}
+
+	inject = false
+	want = []Label{{"collector", "static"}, {"team", "ops"}}
+	if got := targetLabels("prod", "disk", cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("without injection: labels = %v, want %v", got, want)
+	}
+}
+
+func TestInjectLabelsDropsDuplicates(t *testing.T) {
+	families, invalid, err := ParseMetrics("up{cluster=\"a\"} 1\nup{cluster=\"b\"} 0\nup 1")
+	if err != nil || len(invalid) > 0 {
+		t.Fatalf("ParseMetrics() = %v, %v", invalid, err)
+	}
+
+	// Overwriting the cluster label makes the three series identical
+	invalid = injectLabels(families, []Label{{"cluster", "prod"}}, config.LabelConflictOverwrite)
+	if len(invalid) != 2 {
+		t.Errorf("got %d invalid series, want 2: %v", len(invalid), invalid)
+	}
+	want := []Sample{{Name: "up", Labels: []Label{{"cluster", "prod"}}, Value: 1}}
+	if got := families[0].Samples; !reflect.DeepEqual(got, want) {
+		t.Errorf("samples = %+v, want %+v", got, want)
+	}
+}
+
+func TestSeriesLabels(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"prod:disk", `cluster="prod", collector="disk"`},
+		{"prod:db:replica", `cluster="prod", collector="db:replica"`},
+		{`lab "b":c\d`, `cluster="lab \"b\"", collector="c\\d"`},
+	}
+	for _, tt := range tests {
+		if got := SeriesLabels(tt.key); got != tt.want {
+			t.Errorf("SeriesLabels(%q) = %s, want %s", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file handles metric metadata: filling in default HELP/TYPE for families
+// a script left untyped, and merging families with the same name coming from
+// several collectors into one correctly grouped block.
+
+package collector
+
+import (
+	"public_exporter/config"
+	"sort"
+)
+
+// applyMetadataDefaults sets the collector's default type and help on families
+// the script did not describe itself.
+func applyMetadataDefaults(families []*MetricFamily, cfg config.CollectorConfig) {
+	for _, family := range families {
+		if family.Type == MetricTypeUntyped && cfg.DefaultType != "" {
+			family.Type = MetricType(cfg.DefaultType)
+		}
+		if family.Help == "" {
+			family.Help = cfg.DefaultHelp
+		}
+	}
+}
+
+// MergeFamilies merges the families of several collectors by metric name.
+// The first collector to expose a name decides its type and help; families
+// of another type and series already exposed by an earlier collector are
+// skipped, and the number of series skipped is returned per collector. The
+// result is sorted by name and does not share samples with the input.
+func MergeFamilies(sources map[string][]*MetricFamily) ([]*MetricFamily, map[string]int) {
+	keys := make([]string, 0, len(sources))
+	for key := range sources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	merged := make(map[string]*MetricFamily)
+	seen := make(map[string]bool)
+	skipped := make(map[string]int)
+	for _, key := range keys {
+		for _, family := range sources[key] {
+			target, exists := merged[family.Name]
+			if !exists {
+				target = &MetricFamily{Name: family.Name, Help: family.Help, Type: family.Type}
+				merged[family.Name] = target
+			} else if target.Type != family.Type {
+				skipped[key] += len(family.Samples)
+				continue
+			}
+			if target.Help == "" {
+				target.Help = family.Help
+			}
+
+			for _, sample := range family.Samples {
+				id := SeriesID(sample)
+				if seen[id] {
+					skipped[key]++
+					continue
+				}
+				seen[id] = true
+				target.Samples = append(target.Samples, sample)
+			}
+		}
+	}
+
+	families := make([]*MetricFamily, 0, len(merged))
+	for _, family := range merged {
+		families = append(families, family)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+	return families, skipped
+}
@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+// parseFamilies parses script output that is known to be valid
+func parseFamilies(t *testing.T, text string) []*MetricFamily {
+	t.Helper()
+	families, invalid, err := ParseMetrics(text)
+	if err != nil || len(invalid) > 0 {
+		t.Fatalf("ParseMetrics(%q) = %v, %v", text, invalid, err)
+	}
+	return families
+}
+
+func TestMergeFamilies(t *testing.T) {
+	sources := map[string][]*MetricFamily{
+		"a:disk": parseFamilies(t, "# TYPE used gauge\nused{path=\"/\"} 1\n# TYPE errors counter\nerrors 3"),
+		"b:disk": parseFamilies(t, "# HELP used Bytes used\n# TYPE used gauge\nused{path=\"/\"} 2\nused{path=\"/data\"} 4\n# TYPE errors gauge\nerrors 5"),
+	}
+
+	families, skipped := MergeFamilies(sources)
+	if len(families) != 2 || families[0].Name != "errors" || families[1].Name != "used" {
+		t.Fatalf("families = %+v, want errors and used", families)
+	}
+
+	// The first collector decides the type; the help comes from the
+	// first collector that has one
+	errors, used := families[0], families[1]
+	if errors.Type != MetricTypeCounter || len(errors.Samples) != 1 || errors.Samples[0].Value != 3 {
+		t.Errorf("errors = %+v, want the counter of a:disk only", errors)
+	}
+	if used.Help != "Bytes used" {
+		t.Errorf("help = %q, want the help of b:disk", used.Help)
+	}
+	var values []float64
+	for _, sample := range used.Samples {
+		values = append(values, sample.Value)
+	}
+	if want := []float64{1, 4}; !reflect.DeepEqual(values, want) {
+		t.Errorf("used values = %v, want %v", values, want)
+	}
+
+	// The duplicate series and the family of another type are counted
+	if want := map[string]int{"b:disk": 2}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %v, want %v", skipped, want)
+	}
+
+	// The input is left alone
+	if len(sources["a:disk"][1].Samples) != 1 || len(sources["b:disk"][0].Samples) != 2 {
+		t.Errorf("the input families were modified")
+	}
+}
@@ -0,0 +1,506 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file implements a parser for the Prometheus text exposition format.
+// Script output is turned into metric families (name, type, help and samples)
+// so that the collector manager works on structured data instead of raw text,
+// and the families can be rendered back into the exposition format.
+
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricType is the type of a metric family as declared by a "# TYPE" line.
+type MetricType string
+
+const (
+	MetricTypeCounter   MetricType = "counter"
+	MetricTypeGauge     MetricType = "gauge"
+	MetricTypeHistogram MetricType = "histogram"
+	MetricTypeSummary   MetricType = "summary"
+	MetricTypeUntyped   MetricType = "untyped"
+)
+
+// Label is a single label name/value pair of a sample.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a single line of the exposition format.
+type Sample struct {
+	Name      string
+	Labels    []Label
+	Value     float64
+	Timestamp int64 // milliseconds since epoch, 0 if the line has no timestamp
+}
+
+// MetricFamily groups all samples belonging to one metric name.
+type MetricFamily struct {
+	Name    string
+	Help    string
+	Type    MetricType
+	Samples []Sample
+}
+
+// ParseError describes a line of script output that could not be parsed.
+type ParseError struct {
+	Line   int    `json:"line,omitempty"` // 0 if the problem was found after parsing
+	Text   string `json:"text"`
+	Reason string `json:"reason"`
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %q", e.Reason, e.Text)
+	}
+	return fmt.Sprintf("line %d: %s: %q", e.Line, e.Reason, e.Text)
+}
+
+// ParseMetrics parses Prometheus text exposition output into metric families.
+// Families are returned in the order they first appear in the output. Lines
+// that are malformed or would make the exposition invalid (such as duplicate
+// series) are dropped and reported individually, so that one bad line does
+// not discard the rest of the output.
+func ParseMetrics(text string) ([]*MetricFamily, []*ParseError, error) {
+	p := newParser()
+
+	var invalid []*ParseError
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.Trim(scanner.Text(), " \t\r")
+		if line == "" {
+			continue
+		}
+		if reason := p.parseLine(line); reason != "" {
+			invalid = append(invalid, &ParseError{Line: lineNo, Text: line, Reason: reason})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, invalid, fmt.Errorf("failed to read script output: %w", err)
+	}
+
+	return p.order, invalid, nil
+}
+
+type parser struct {
+	families map[string]*MetricFamily
+	typed    map[string]bool
+	series   map[string]bool
+	order    []*MetricFamily
+}
+
+func newParser() *parser {
+	return &parser{
+		families: make(map[string]*MetricFamily),
+		typed:    make(map[string]bool),
+		series:   make(map[string]bool),
+	}
+}
+
+// parseLine parses one non-empty line and returns a reason if it is invalid.
+func (p *parser) parseLine(line string) string {
+	if strings.HasPrefix(line, "#") {
+		return p.parseComment(line)
+	}
+
+	sample, reason := parseSample(line)
+	if reason != "" {
+		return reason
+	}
+
+	family := p.familyFor(sample.Name)
+	if reason := checkSample(family, sample); reason != "" {
+		return reason
+	}
+
+	id := SeriesID(sample)
+	if p.series[id] {
+		return "duplicate series"
+	}
+	p.series[id] = true
+
+	family.Samples = append(family.Samples, sample)
+	return ""
+}
+
+// checkSample validates type specific requirements of a sample.
+func checkSample(family *MetricFamily, sample Sample) string {
+	switch family.Type {
+	case MetricTypeHistogram:
+		if sample.Name == family.Name+"_bucket" {
+			le, ok := labelValue(sample.Labels, "le")
+			if !ok {
+				return "histogram bucket without le label"
+			}
+			if _, err := strconv.ParseFloat(le, 64); err != nil {
+				return fmt.Sprintf("invalid le label value %q", le)
+			}
+		} else if sample.Name == family.Name {
+			return "histogram sample without _bucket, _sum or _count suffix"
+		}
+	case MetricTypeSummary:
+		if sample.Name == family.Name {
+			quantile, ok := labelValue(sample.Labels, "quantile")
+			if !ok {
+				return "summary sample without quantile label"
+			}
+			if _, err := strconv.ParseFloat(quantile, 64); err != nil {
+				return fmt.Sprintf("invalid quantile label value %q", quantile)
+			}
+		}
+	}
+	return ""
+}
+
+// SeriesID returns a key identifying the series of a sample, independent of
+// the order of its labels.
+func SeriesID(sample Sample) string {
+	labels := make([]string, 0, len(sample.Labels))
+	for _, label := range sample.Labels {
+		labels = append(labels, label.Name+"=\""+escapeLabelValue(label.Value)+"\"")
+	}
+	sort.Strings(labels)
+	return sample.Name + "{" + strings.Join(labels, ",") + "}"
+}
+
+func labelValue(labels []Label, name string) (string, bool) {
+	for _, label := range labels {
+		if label.Name == name {
+			return label.Value, true
+		}
+	}
+	return "", false
+}
+
+// parseComment handles HELP and TYPE lines; any other comment is ignored.
+func (p *parser) parseComment(line string) string {
+	fields := strings.Fields(strings.TrimPrefix(line, "#"))
+	if len(fields) < 2 || (fields[0] != "HELP" && fields[0] != "TYPE") {
+		return ""
+	}
+
+	keyword, name := fields[0], fields[1]
+	if !isValidMetricName(name) {
+		return fmt.Sprintf("invalid metric name %q in %s line", name, keyword)
+	}
+
+	family, exists := p.families[name]
+	if !exists {
+		family = p.addFamily(name)
+	}
+
+	switch keyword {
+	case "HELP":
+		if family.Help != "" {
+			return fmt.Sprintf("second HELP line for metric %s", name)
+		}
+		// The docstring is everything after the metric name, whitespace preserved.
+		rest := strings.TrimLeft(strings.TrimPrefix(line, "#"), " \t")
+		rest = strings.TrimLeft(strings.TrimPrefix(rest, "HELP"), " \t")
+		rest = strings.TrimLeft(strings.TrimPrefix(rest, name), " \t")
+		family.Help = unescapeHelp(rest)
+	case "TYPE":
+		if len(fields) != 3 {
+			return "TYPE line must have exactly a metric name and a type"
+		}
+		if p.typed[name] {
+			return fmt.Sprintf("second TYPE line for metric %s", name)
+		}
+		if len(family.Samples) > 0 {
+			return fmt.Sprintf("TYPE line for metric %s after its samples", name)
+		}
+		metricType := MetricType(fields[2])
+		switch metricType {
+		case MetricTypeCounter, MetricTypeGauge, MetricTypeHistogram, MetricTypeSummary, MetricTypeUntyped:
+			family.Type = metricType
+			p.typed[name] = true
+		default:
+			return fmt.Sprintf("unknown metric type %q", fields[2])
+		}
+	}
+	return ""
+}
+
+func (p *parser) addFamily(name string) *MetricFamily {
+	family := &MetricFamily{Name: name, Type: MetricTypeUntyped}
+	p.families[name] = family
+	p.order = append(p.order, family)
+	return family
+}
+
+// familyFor returns the family a sample belongs to, taking the _bucket, _sum
+// and _count suffixes of histograms and summaries into account.
+func (p *parser) familyFor(sampleName string) *MetricFamily {
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		base := strings.TrimSuffix(sampleName, suffix)
+		if base == sampleName {
+			continue
+		}
+		if family, ok := p.families[base]; ok {
+			if family.Type == MetricTypeHistogram || (family.Type == MetricTypeSummary && suffix != "_bucket") {
+				return family
+			}
+		}
+	}
+	if family, ok := p.families[sampleName]; ok {
+		return family
+	}
+	return p.addFamily(sampleName)
+}
+
+// parseSample parses a sample line of the form
+// name{label="value",...} value [timestamp]
+func parseSample(line string) (Sample, string) {
+	var sample Sample
+
+	i := 0
+	for i < len(line) && isMetricNameChar(line[i], i == 0) {
+		i++
+	}
+	if i == 0 {
+		return sample, "invalid metric name"
+	}
+	sample.Name = line[:i]
+
+	if i < len(line) && line[i] == '{' {
+		labels, n, reason := parseLabels(line[i+1:])
+		if reason != "" {
+			return sample, reason
+		}
+		sample.Labels = labels
+		i += n + 1
+	}
+
+	if i >= len(line) || (line[i] != ' ' && line[i] != '\t') {
+		return sample, "expected whitespace before value"
+	}
+
+	fields := strings.Fields(line[i:])
+	if len(fields) == 0 {
+		return sample, "missing value"
+	}
+	if len(fields) > 2 {
+		return sample, "unexpected text after timestamp"
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sample, fmt.Sprintf("invalid value %q", fields[0])
+	}
+	sample.Value = value
+
+	if len(fields) == 2 {
+		ts, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return sample, fmt.Sprintf("invalid timestamp %q", fields[1])
+		}
+		sample.Timestamp = ts
+	}
+
+	return sample, ""
+}
+
+// parseLabels parses the label set following an opening brace and returns
+// the labels and the number of bytes consumed, including the closing brace.
+func parseLabels(s string) ([]Label, int, string) {
+	var labels []Label
+	seen := make(map[string]bool)
+
+	i := 0
+	skipSpace := func() {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+	}
+
+	for {
+		skipSpace()
+		if i >= len(s) {
+			return nil, 0, "unterminated label set"
+		}
+		if s[i] == '}' {
+			return labels, i + 1, ""
+		}
+
+		start := i
+		for i < len(s) && isLabelNameChar(s[i], i == start) {
+			i++
+		}
+		if i == start {
+			return nil, 0, "invalid label name"
+		}
+		name := s[start:i]
+
+		skipSpace()
+		if i >= len(s) || s[i] != '=' {
+			return nil, 0, fmt.Sprintf("expected '=' after label name %s", name)
+		}
+		i++
+		skipSpace()
+		if i >= len(s) || s[i] != '"' {
+			return nil, 0, fmt.Sprintf("expected quoted value for label %s", name)
+		}
+		i++
+
+		var value strings.Builder
+		closed := false
+		for i < len(s) {
+			c := s[i]
+			if c == '"' {
+				closed = true
+				i++
+				break
+			}
+			if c == '\\' {
+				if i+1 >= len(s) {
+					break
+				}
+				i++
+				switch s[i] {
+				case '\\':
+					value.WriteByte('\\')
+				case '"':
+					value.WriteByte('"')
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					return nil, 0, fmt.Sprintf("invalid escape sequence in value of label %s", name)
+				}
+				i++
+				continue
+			}
+			value.WriteByte(c)
+			i++
+		}
+		if !closed {
+			return nil, 0, fmt.Sprintf("unterminated value for label %s", name)
+		}
+
+		if seen[name] {
+			return nil, 0, fmt.Sprintf("duplicate label %s", name)
+		}
+		seen[name] = true
+		labels = append(labels, Label{Name: name, Value: value.String()})
+
+		skipSpace()
+		if i < len(s) && s[i] == ',' {
+			i++
+			continue
+		}
+		if i < len(s) && s[i] == '}' {
+			return labels, i + 1, ""
+		}
+		return nil, 0, "expected ',' or '}' in label set"
+	}
+}
+
+// WriteMetrics renders metric families in the Prometheus text exposition format.
+func WriteMetrics(w io.Writer, families []*MetricFamily) error {
+	for _, family := range families {
+		if family.Help != "" {
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n", family.Name, escapeHelp(family.Help)); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", family.Name, family.Type); err != nil {
+			return err
+		}
+		for _, sample := range family.Samples {
+			if _, err := io.WriteString(w, FormatSample(sample)+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FormatMetrics renders metric families into a string.
+func FormatMetrics(families []*MetricFamily) string {
+	var b strings.Builder
+	WriteMetrics(&b, families)
+	return b.String()
+}
+
+// FormatSample renders a single sample line without a trailing newline.
+func FormatSample(sample Sample) string {
+	var b strings.Builder
+	b.WriteString(sample.Name)
+	if len(sample.Labels) > 0 {
+		b.WriteByte('{')
+		for i, label := range sample.Labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label.Name)
+			b.WriteString(`="`)
+			b.WriteString(escapeLabelValue(label.Value))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatValue(sample.Value))
+	if sample.Timestamp != 0 {
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatInt(sample.Timestamp, 10))
+	}
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func isMetricNameChar(c byte, first bool) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == ':' || (!first && c >= '0' && c <= '9')
+}
+
+func isLabelNameChar(c byte, first bool) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || (!first && c >= '0' && c <= '9')
+}
+
+func isValidMetricName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isMetricNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func unescapeHelp(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
@@ -0,0 +1,116 @@
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMetricsEscapes(t *testing.T) {
+	text := strings.Join([]string{
+		`# HELP path_info Path of a file \\ with a\nnewline`,
+		`# TYPE path_info gauge`,
+		`path_info{path="C:\\temp",quote="say \"hi\"",multi="a\nb"} 1`,
+	}, "\n")
+
+	families, invalid, err := ParseMetrics(text)
+	if err != nil || len(invalid) > 0 {
+		t.Fatalf("ParseMetrics() = %v, %v", invalid, err)
+	}
+	if len(families) != 1 {
+		t.Fatalf("got %d families, want 1", len(families))
+	}
+	family := families[0]
+	if want := "Path of a file \\ with a\nnewline"; family.Help != want {
+		t.Errorf("help = %q, want %q", family.Help, want)
+	}
+	want := []Label{{"path", `C:\temp`}, {"quote", `say "hi"`}, {"multi", "a\nb"}}
+	if got := family.Samples[0].Labels; !reflect.DeepEqual(got, want) {
+		t.Errorf("labels = %q, want %q", got, want)
+	}
+
+	// Rendering escapes the values again, so the output parses to the same
+	rendered, _, err := ParseMetrics(FormatMetrics(families))
+	if err != nil || !reflect.DeepEqual(rendered, families) {
+		t.Errorf("round trip = %+v, %v, want %+v", rendered, err, families)
+	}
+}
+
+func TestParseMetricsInvalidEscape(t *testing.T) {
+	_, invalid, _ := ParseMetrics(`m{a="\t"} 1`)
+	if len(invalid) != 1 || !strings.Contains(invalid[0].Reason, "invalid escape sequence") {
+		t.Errorf("invalid = %v, want an invalid escape sequence", invalid)
+	}
+}
+
+func TestParseMetricsSuffixes(t *testing.T) {
+	text := strings.Join([]string{
+		`# TYPE latency histogram`,
+		`latency_bucket{le="0.5"} 1`,
+		`latency_bucket{le="+Inf"} 2`,
+		`latency_sum 0.9`,
+		`latency_count 2`,
+		`# TYPE rpc summary`,
+		`rpc{quantile="0.99"} 0.3`,
+		`rpc_sum 1.5`,
+		`rpc_count 7`,
+		`rpc_bucket 1`,
+		`requests_count 3`,
+	}, "\n")
+
+	families, invalid, err := ParseMetrics(text)
+	if err != nil || len(invalid) > 0 {
+		t.Fatalf("ParseMetrics() = %v, %v", invalid, err)
+	}
+	counts := make(map[string]int)
+	for _, family := range families {
+		counts[family.Name] = len(family.Samples)
+	}
+	// Summaries have no buckets, and untyped families no suffixes
+	want := map[string]int{"latency": 4, "rpc": 3, "rpc_bucket": 1, "requests_count": 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("samples per family = %v, want %v", counts, want)
+	}
+}
+
+func TestParseMetricsInvalidLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		reason string
+	}{
+		{"duplicate series", "m{a=\"1\",b=\"2\"} 1\nm{b=\"2\",a=\"1\"} 2", "duplicate series"},
+		{"TYPE after sample", "m 1\n# TYPE m gauge", "TYPE line for metric m after its samples"},
+		{"second TYPE", "# TYPE m gauge\n# TYPE m counter", "second TYPE line for metric m"},
+		{"bucket without le", "# TYPE h histogram\nh_bucket 1", "histogram bucket without le label"},
+		{"histogram without suffix", "# TYPE h histogram\nh 1", "histogram sample without _bucket, _sum or _count suffix"},
+		{"summary without quantile", "# TYPE s summary\ns 1", "summary sample without quantile label"},
+		{"duplicate label", `m{a="1",a="2"} 1`, "duplicate label a"},
+		{"invalid value", "m one", `invalid value "one"`},
+		{"text after timestamp", "m 1 2 3", "unexpected text after timestamp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, invalid, err := ParseMetrics(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(invalid) != 1 || invalid[0].Reason != tt.reason {
+				t.Errorf("invalid = %v, want one line with reason %q", invalid, tt.reason)
+			}
+		})
+	}
+}
+
+func TestParseMetricsKeepsValidLines(t *testing.T) {
+	families, invalid, err := ParseMetrics("a 1\nbad line\nb 2 1700000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invalid) != 1 || invalid[0].Line != 2 {
+		t.Errorf("invalid = %v, want line 2", invalid)
+	}
+	if len(families) != 2 || families[1].Samples[0].Timestamp != 1700000000000 {
+		t.Errorf("families = %+v, want a and b with a timestamp", families)
+	}
+}
@@ -0,0 +1,72 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// Process handling specific to Linux. Whether a process group still has
+// members is read from /proc, so that zombies, which only wait for their
+// parent to reap them, don't keep a script's group alive.
+
+package collector
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// processGroupPending reports whether a process group has a member that is
+// still running, or that exited and has yet to be reaped by the exporter,
+// according to /proc. Zombies of other parents don't count. Without /proc
+// any member counts.
+func processGroupPending(pgid int) bool {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return syscall.Kill(-pgid, 0) == nil
+	}
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		stat, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue // the process is gone
+		}
+		proc, ok := parseProcStat(stat)
+		if !ok || proc.pgid != pgid {
+			continue
+		}
+		if (proc.state != 'Z' && proc.state != 'X') || proc.ppid == os.Getpid() {
+			return true
+		}
+	}
+	return false
+}
+
+// procStat holds the fields of /proc/<pid>/stat the exporter uses
+type procStat struct {
+	state byte // R, S, D, Z (zombie), ...
+	ppid  int
+	pgid  int
+}
+
+// parseProcStat parses the contents of /proc/<pid>/stat: "pid (comm) state
+// ppid pgrp ...". The command name may contain spaces and parentheses, so
+// the fields are read after its last ")".
+func parseProcStat(stat []byte) (procStat, bool) {
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return procStat{}, false
+	}
+	fields := bytes.Fields(stat[end+1:])
+	if len(fields) < 3 || len(fields[0]) != 1 {
+		return procStat{}, false
+	}
+	ppid, err1 := strconv.Atoi(string(fields[1]))
+	pgid, err2 := strconv.Atoi(string(fields[2]))
+	if err1 != nil || err2 != nil {
+		return procStat{}, false
+	}
+	return procStat{state: fields[0][0], ppid: ppid, pgid: pgid}, true
+}
@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// zombieChildren returns the zombie children of the test process
+func zombieChildren(t *testing.T) []string {
+	t.Helper()
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		t.Skip("/proc is not available")
+	}
+	var zombies []string
+	for _, entry := range entries {
+		stat, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		if proc, ok := parseProcStat(stat); ok && proc.state == 'Z' && proc.ppid == os.Getpid() {
+			zombies = append(zombies, entry.Name())
+		}
+	}
+	return zombies
+}
+
+func TestParseProcStat(t *testing.T) {
+	proc, ok := parseProcStat([]byte("4242 (a (weird) name) Z 17 4200 4200 0 -1 4194560"))
+	if want := (procStat{state: 'Z', ppid: 17, pgid: 4200}); !ok || proc != want {
+		t.Errorf("parseProcStat() = %+v, %v, want %+v", proc, ok, want)
+	}
+	if _, ok := parseProcStat([]byte("4242 (truncated")); ok {
+		t.Error("parseProcStat() accepted a truncated line")
+	}
+}
+
+func TestExecuteScriptReapsLeftovers(t *testing.T) {
+	// The background sleep outlives the script and must neither delay the
+	// execution by the grace period nor stay a zombie once it is terminated
+	start := time.Now()
+	result, err := runInline(t, newTestExecutor(5*time.Second), "sleep 30 >/dev/null 2>&1 &\necho up 1", 5*time.Second)
+	elapsed := time.Since(start)
+	if err != nil || result.Output != "up 1\n" {
+		t.Fatalf("ExecuteScript() = %+v, %v", result, err)
+	}
+	if !result.OrphansKilled {
+		t.Error("the leftover sleep was not reported as killed")
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("execution took %v, want the leftover to be gone well within the 5s grace period", elapsed)
+	}
+	if zombies := zombieChildren(t); len(zombies) > 0 {
+		t.Errorf("zombie processes left behind: %v", zombies)
+	}
+}
+
+func TestExecuteScriptTimeoutReapsGroup(t *testing.T) {
+	start := time.Now()
+	result, err := runInline(t, newTestExecutor(5*time.Second), "sleep 30 >/dev/null 2>&1 &\nsleep 30", time.Second)
+	elapsed := time.Since(start)
+	if err == nil || result.Failure != FailureTimeout {
+		t.Fatalf("result = %+v, %v, want a timeout", result, err)
+	}
+	if elapsed > 2500*time.Millisecond {
+		t.Errorf("execution took %v, want about the 1s timeout rather than the 5s grace period", elapsed)
+	}
+	if zombies := zombieChildren(t); len(zombies) > 0 {
+		t.Errorf("zombie processes left behind: %v", zombies)
+	}
+}
+
+func TestExecuteScriptLeavesOtherSessionsToInit(t *testing.T) {
+	// Processes that leave the script's process group are not the exporter's
+	// to wait for, and must not become its zombies when they exit
+	se := newTestExecutor(5 * time.Second)
+	for i := 0; i < 3; i++ {
+		result, err := runInline(t, se, "setsid sh -c 'sleep 0.2' >/dev/null 2>&1 </dev/null &\necho up 1", 5*time.Second)
+		if err != nil || result.Output != "up 1\n" {
+			t.Fatalf("ExecuteScript() = %+v, %v", result, err)
+		}
+	}
+	time.Sleep(time.Second)
+	if zombies := zombieChildren(t); len(zombies) > 0 {
+		t.Errorf("zombie processes left behind: %v", zombies)
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !windows
+
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// Process group handling for Unix systems. Scripts are started as the leader
+// of a new process group so that signals reach every process they spawn.
+// When the exporter runs as PID 1, it inherits the orphans of the group and
+// reaps them here, since nothing else waits for them.
+
+package collector
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func terminateProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// processGroupAlive reaps the processes of the group that exited and are
+// children of the exporter, and reports whether any process of the group
+// is still running or left to reap. Zombies of other parents don't count.
+// It must only be called once the script itself has been waited for.
+func processGroupAlive(cmd *exec.Cmd) bool {
+	reapProcessGroup(cmd.Process.Pid)
+	return processGroupPending(cmd.Process.Pid)
+}
+
+// reapProcessGroup waits for the exited children of the exporter in a
+// process group without blocking.
+func reapProcessGroup(pgid int) {
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-pgid, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows && !linux
+
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// Process handling for Unix systems other than Linux, which have no /proc to
+// tell zombies apart from running processes.
+
+package collector
+
+import (
+	"syscall"
+)
+
+// processGroupPending reports whether a process group has any member left.
+func processGroupPending(pgid int) bool {
+	return syscall.Kill(-pgid, 0) == nil
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// Process handling for Windows, which has no process groups to signal. Only
+// the script process itself is killed on timeout.
+
+package collector
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func terminateProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+func processGroupAlive(cmd *exec.Cmd) bool {
+	return false
+}
@@ -0,0 +1,148 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file implements when a collector runs: every interval seconds or at
+// the times of a cron schedule, restricted to its active windows, and spread
+// out by splay, jitter and a staggered startup.
+
+package collector
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"public_exporter/config"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// maxScheduleSteps bounds the search for a scheduled time within the active
+// windows, for schedules that never hit one.
+const maxScheduleSteps = 1000
+
+// schedule decides when a collector runs
+type schedule struct {
+	cron     cron.Schedule // nil for a fixed interval
+	interval time.Duration
+	aligned  bool // interval runs are at offset past multiples of interval
+	offset   time.Duration
+	jitter   time.Duration // maximum random delay of a run
+	windows  []config.TimeWindow
+	location *time.Location
+}
+
+func newSchedule(cfg config.CollectorConfig) (*schedule, error) {
+	location, err := cfg.Location()
+	if err != nil {
+		return nil, err
+	}
+	cronSchedule, err := cfg.CronSchedule()
+	if err != nil {
+		return nil, err
+	}
+	return &schedule{
+		cron:     cronSchedule,
+		interval: time.Duration(cfg.Interval) * time.Second,
+		jitter:   time.Duration(cfg.Jitter) * time.Second,
+		windows:  cfg.ActiveWindows,
+		location: location,
+	}, nil
+}
+
+// active reports whether the collector may run at t
+func (s *schedule) active(t time.Time) bool {
+	if len(s.windows) == 0 {
+		return true
+	}
+	t = t.In(s.location)
+	for _, window := range s.windows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the first time after t at which the collector runs, or the
+// zero time if it never does.
+func (s *schedule) next(t time.Time) time.Time {
+	next := s.step(t)
+	for i := 0; i < maxScheduleSteps && !next.IsZero(); i++ {
+		if s.active(next) {
+			return next
+		}
+		// Skip ahead to the next window instead of stepping through the gap
+		start := s.nextWindowStart(next)
+		if start.IsZero() {
+			return start
+		}
+		if s.cron == nil && !s.aligned {
+			next = start
+		} else {
+			next = s.step(start.Add(-time.Nanosecond))
+		}
+	}
+	return time.Time{}
+}
+
+// step returns the next time after t in the schedule, ignoring windows
+func (s *schedule) step(t time.Time) time.Time {
+	if s.cron != nil {
+		return s.cron.Next(t)
+	}
+	if s.aligned {
+		// The first time after t that is offset past a multiple of interval
+		since := t.Sub(time.Unix(0, 0).Add(s.offset)) % s.interval
+		if since < 0 {
+			since += s.interval
+		}
+		return t.Add(s.interval - since)
+	}
+	return t.Add(s.interval)
+}
+
+// delay returns the random delay of one run
+func (s *schedule) delay() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.jitter)))
+}
+
+// splay aligns interval runs to a deterministic offset within the interval,
+// so the same collector on different hosts, and different collectors on the
+// same host, run at different times.
+func (s *schedule) splay(clusterName, collectorName string) {
+	if s.cron != nil {
+		return
+	}
+	s.aligned = true
+	s.offset = spread(clusterName, collectorName, s.interval)
+}
+
+// spread returns a deterministic duration below limit for a collector on this
+// host
+func spread(clusterName, collectorName string, limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	hostname, _ := os.Hostname()
+	h := fnv.New64a()
+	h.Write([]byte(hostname + "/" + clusterName + "/" + collectorName))
+	return time.Duration(h.Sum64() % uint64(limit))
+}
+
+// nextWindowStart returns the earliest start of an active window after t
+func (s *schedule) nextWindowStart(t time.Time) time.Time {
+	var earliest time.Time
+	for _, window := range s.windows {
+		start := window.NextStart(t.In(s.location))
+		if !start.IsZero() && (earliest.IsZero() || start.Before(earliest)) {
+			earliest = start
+		}
+	}
+	return earliest
+}
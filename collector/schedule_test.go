@@ -0,0 +1,201 @@
+package collector
+
+import (
+	"public_exporter/config"
+	"testing"
+	"time"
+)
+
+// loadLocation returns a time zone, skipping the test without the zone
+// database
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skip(err)
+	}
+	return location
+}
+
+func mustSchedule(t *testing.T, cfg config.CollectorConfig) *schedule {
+	t.Helper()
+	s, err := newSchedule(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// at returns a time in April 2025 in UTC; the 4th is a Friday
+func at(day, hour, minute int) time.Time {
+	return time.Date(2025, time.April, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestScheduleNextCronInTimezone(t *testing.T) {
+	shanghai := loadLocation(t, "Asia/Shanghai")
+	s := mustSchedule(t, config.CollectorConfig{Schedule: "0 9 * * *", Timezone: "Asia/Shanghai"})
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want time.Time
+	}{
+		// 00:00 UTC is 08:00 in Shanghai
+		{"later the same day", at(4, 0, 0), time.Date(2025, time.April, 4, 9, 0, 0, 0, shanghai)},
+		{"at the scheduled time", at(4, 1, 0), time.Date(2025, time.April, 5, 9, 0, 0, 0, shanghai)},
+		{"on the next day", at(4, 2, 0), time.Date(2025, time.April, 5, 9, 0, 0, 0, shanghai)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.next(tt.t); !got.Equal(tt.want) {
+				t.Errorf("next(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextSkipsToActiveWindow(t *testing.T) {
+	office := config.TimeWindow{Start: "09:00", End: "17:00"}
+	overnight := config.TimeWindow{Days: []string{"fri"}, Start: "22:00", End: "02:00"}
+
+	tests := []struct {
+		name string
+		cfg  config.CollectorConfig
+		t    time.Time
+		want time.Time
+	}{
+		{"interval within a window", config.CollectorConfig{Interval: 60, ActiveWindows: []config.TimeWindow{office}},
+			at(4, 10, 0), at(4, 10, 1)},
+		{"interval after a window runs at the next start", config.CollectorConfig{Interval: 60, ActiveWindows: []config.TimeWindow{office}},
+			at(4, 16, 59).Add(30 * time.Second), at(5, 9, 0)},
+		{"cron before a window", config.CollectorConfig{Schedule: "*/15 * * * *", ActiveWindows: []config.TimeWindow{overnight}},
+			at(4, 12, 0), at(4, 22, 0)},
+		{"cron in a window after midnight", config.CollectorConfig{Schedule: "*/15 * * * *", ActiveWindows: []config.TimeWindow{overnight}},
+			at(5, 1, 40), at(5, 1, 45)},
+		{"cron at the end of a window crossing midnight", config.CollectorConfig{Schedule: "*/15 * * * *", ActiveWindows: []config.TimeWindow{overnight}},
+			at(5, 1, 50), at(11, 22, 0)},
+		{"cron never in a window", config.CollectorConfig{Schedule: "0 12 * * *", ActiveWindows: []config.TimeWindow{{Start: "13:00", End: "14:00"}}},
+			at(4, 0, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Timezone = "UTC"
+			if got := mustSchedule(t, tt.cfg).next(tt.t); !got.Equal(tt.want) {
+				t.Errorf("next(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextAcrossDST(t *testing.T) {
+	berlin := loadLocation(t, "Europe/Berlin")
+	date := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2025, month, day, hour, minute, 0, 0, berlin)
+	}
+	// Clocks go forward at 02:00 on March 30 and back at 03:00 on October 26
+	tests := []struct {
+		name string
+		cfg  config.CollectorConfig
+		t    time.Time
+		want time.Time
+	}{
+		{"cron keeps the wall clock time in spring", config.CollectorConfig{Schedule: "0 9 * * *"},
+			date(time.March, 29, 10, 0), date(time.March, 30, 9, 0)},
+		{"cron keeps the wall clock time in autumn", config.CollectorConfig{Schedule: "0 9 * * *"},
+			date(time.October, 25, 10, 0), date(time.October, 26, 9, 0)},
+		{"cron skips a time that doesn't exist", config.CollectorConfig{Schedule: "30 2 * * *"},
+			date(time.March, 29, 12, 0), date(time.March, 31, 2, 30)},
+		{"cron runs a repeated time once", config.CollectorConfig{Schedule: "30 2 * * *"},
+			date(time.October, 26, 2, 40), date(time.October, 27, 2, 30)},
+		{"an interval counts elapsed time", config.CollectorConfig{Interval: 3600},
+			date(time.March, 30, 1, 30), date(time.March, 30, 3, 30)},
+		{"a window starts at its wall clock time", config.CollectorConfig{Interval: 3600, ActiveWindows: []config.TimeWindow{{Start: "08:00", End: "09:00"}}},
+			date(time.March, 29, 8, 30), date(time.March, 30, 8, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Timezone = "Europe/Berlin"
+			if got := mustSchedule(t, tt.cfg).next(tt.t); !got.Equal(tt.want) {
+				t.Errorf("next(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleAlignedOffsets(t *testing.T) {
+	aligned := config.CollectorConfig{Interval: 60, Timezone: "UTC"}
+	withWindow := aligned
+	withWindow.ActiveWindows = []config.TimeWindow{{Start: "09:00", End: "17:00"}}
+
+	tests := []struct {
+		name string
+		cfg  config.CollectorConfig
+		t    time.Time
+		want time.Time
+	}{
+		{"before the offset", aligned, at(4, 12, 0), at(4, 12, 0).Add(15 * time.Second)},
+		{"at the offset", aligned, at(4, 12, 0).Add(15 * time.Second), at(4, 12, 1).Add(15 * time.Second)},
+		{"after the offset", aligned, at(4, 12, 0).Add(20 * time.Second), at(4, 12, 1).Add(15 * time.Second)},
+		{"after a window", withWindow, at(4, 17, 0), at(5, 9, 0).Add(15 * time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := mustSchedule(t, tt.cfg)
+			s.aligned, s.offset = true, 15*time.Second
+			if got := s.next(tt.t); !got.Equal(tt.want) {
+				t.Errorf("next(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleSplay(t *testing.T) {
+	interval := mustSchedule(t, config.CollectorConfig{Interval: 300})
+	interval.splay("prod", "disk")
+	if !interval.aligned || interval.offset != spread("prod", "disk", 5*time.Minute) {
+		t.Errorf("splay() = aligned %v, offset %v, want the collector's spread", interval.aligned, interval.offset)
+	}
+	cron := mustSchedule(t, config.CollectorConfig{Schedule: "*/5 * * * *"})
+	cron.splay("prod", "disk")
+	if cron.aligned {
+		t.Error("splay() aligned a cron schedule")
+	}
+
+	// The offset is the same for a collector on every call, and differs
+	// between collectors
+	offsets := make(map[time.Duration]bool)
+	for _, name := range []string{"disk", "cpu", "memory", "network"} {
+		offset := spread("prod", name, time.Hour)
+		if offset < 0 || offset >= time.Hour {
+			t.Errorf("spread(%s) = %v, want it within the interval", name, offset)
+		}
+		if again := spread("prod", name, time.Hour); again != offset {
+			t.Errorf("spread(%s) = %v, then %v", name, offset, again)
+		}
+		offsets[offset] = true
+	}
+	if len(offsets) < 2 {
+		t.Errorf("all collectors got the same offset: %v", offsets)
+	}
+	if offset := spread("prod", "disk", 0); offset != 0 {
+		t.Errorf("spread() without a limit = %v, want 0", offset)
+	}
+}
+
+func TestScheduleDelay(t *testing.T) {
+	if delay := mustSchedule(t, config.CollectorConfig{Interval: 60}).delay(); delay != 0 {
+		t.Errorf("delay() without jitter = %v, want 0", delay)
+	}
+	s := mustSchedule(t, config.CollectorConfig{Interval: 60, Jitter: 2})
+	delays := make(map[time.Duration]bool)
+	for i := 0; i < 1000; i++ {
+		delay := s.delay()
+		if delay < 0 || delay >= 2*time.Second {
+			t.Fatalf("delay() = %v, want it within the jitter of 2s", delay)
+		}
+		delays[delay] = true
+	}
+	if len(delays) < 2 {
+		t.Error("delay() is not random")
+	}
+}
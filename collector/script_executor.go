@@ -0,0 +1,411 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file implements the ScriptExecutor, which runs collector scripts with a
+// timeout. Scripts run in their own process group so that everything they
+// spawn is terminated together when the timeout expires.
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"public_exporter/config"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// processGroupPollInterval is how often a timed-out script's process group is
+// checked for remaining processes during the grace period.
+const processGroupPollInterval = 100 * time.Millisecond
+
+// killedGroupPolls bounds how often a process group is checked for processes
+// that have yet to exit after SIGKILL, so that they can be reaped.
+const killedGroupPolls = 10
+
+// Reasons a collector run failed for
+const (
+	FailureStart    = "start"     // the script could not be started
+	FailureTimeout  = "timeout"   // the script exceeded its timeout
+	FailureExitCode = "exit_code" // the script exited with an error or was killed
+	FailureParse    = "parse"     // the script's output could not be parsed
+)
+
+// FailureReasons are all reasons a collector run can fail for
+var FailureReasons = []string{FailureStart, FailureTimeout, FailureExitCode, FailureParse}
+
+// ExecResult holds the result of a script execution
+type ExecResult struct {
+	Output          string // standard output, the metrics
+	Stderr          string // standard error, truncated to MaxStderrBytes
+	StderrTruncated bool
+	ExecTime        string
+	Duration        time.Duration
+	ExitCode        int    // -1 if the script did not start or was killed by a signal
+	OrphansKilled   bool   // processes left behind by the script had to be killed
+	Failure         string // why the execution failed, empty if it succeeded
+}
+
+// ScriptExecutor handles script execution with proper timeout and error handling.
+// It is safe for concurrent use; concurrency is bounded by the worker pool.
+type ScriptExecutor struct {
+	// KillGracePeriod is how long a timed-out script's process group gets to
+	// exit after SIGTERM before it is killed with SIGKILL.
+	KillGracePeriod time.Duration
+	// MaxStderrBytes is the amount of standard error kept per execution.
+	MaxStderrBytes int
+}
+
+// ScriptRequest describes one script execution
+type ScriptRequest struct {
+	Cluster     string
+	Collector   string
+	ScriptPath  string   // absolute, empty for an inline script
+	Inline      string   // script body, materialized in a temporary file if set
+	Interpreter []string // nil runs the script directly
+	Args        []string
+	Env         map[string]string
+	EnvFromFile string
+	Workdir     string
+	InheritEnv  bool
+	Timeout     time.Duration
+}
+
+// NewScriptRequest builds the request for running a configured collector
+func NewScriptRequest(cfg *config.Config, clusterName, collectorName string, collectorCfg config.CollectorConfig) (ScriptRequest, error) {
+	interpreter, err := cfg.InterpreterCommand(collectorCfg)
+	if err != nil {
+		return ScriptRequest{}, err
+	}
+	return ScriptRequest{
+		Cluster:     clusterName,
+		Collector:   collectorName,
+		ScriptPath:  collectorCfg.ScriptFile(),
+		Inline:      collectorCfg.Inline,
+		Interpreter: interpreter,
+		Args:        collectorCfg.Args,
+		Env:         collectorCfg.Env,
+		EnvFromFile: collectorCfg.EnvFromFile,
+		Workdir:     collectorCfg.Workdir,
+		InheritEnv:  collectorCfg.InheritEnv == nil || *collectorCfg.InheritEnv,
+		Timeout:     time.Duration(collectorCfg.Timeout) * time.Second,
+	}, nil
+}
+
+// ExecuteScript runs a script until it exits, its timeout expires or ctx is
+// done, whichever comes first. A cancelled script is terminated like one that
+// timed out; ctx reaching its deadline counts as a timeout.
+func (se *ScriptExecutor) ExecuteScript(ctx context.Context, req ScriptRequest) (*ExecResult, error) {
+	start := time.Now()
+	result := &ExecResult{
+		ExecTime: start.Format("2006-01-02 15:04:05.000"),
+		ExitCode: -1,
+		Failure:  FailureStart,
+	}
+
+	env, err := scriptEnv(req, start.Add(req.Timeout))
+	if err != nil {
+		return result, err
+	}
+
+	scriptPath := req.ScriptPath
+	if req.Inline != "" {
+		scriptPath, err = writeInlineScript(req)
+		if err != nil {
+			return result, err
+		}
+		defer os.Remove(scriptPath)
+	}
+
+	// The script is either run directly or passed to its interpreter
+	argv := append(append(append([]string{}, req.Interpreter...), scriptPath), req.Args...)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = env
+	cmd.Dir = req.Workdir
+
+	// The script writes into pipes we read ourselves, so that its exit is
+	// noticed even while leftover processes still hold the pipes open
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return result, fmt.Errorf("failed to create output pipe: %v", err)
+	}
+	defer stdoutReader.Close()
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdoutWriter.Close()
+		return result, fmt.Errorf("failed to create output pipe: %v", err)
+	}
+	defer stderrReader.Close()
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+	setProcessGroup(cmd)
+
+	err = cmd.Start()
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		return result, fmt.Errorf("failed to start script: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	stderr := &limitedBuffer{limit: se.MaxStderrBytes}
+	stdoutCopied := captureOutput(stdoutReader, &stdout)
+	stderrCopied := captureOutput(stderrReader, stderr)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+
+	timedOut, cancelled, leftovers := false, false, false
+	select {
+	case err = <-done:
+		// Processes the script left running get SIGTERM right away, and the
+		// grace period to exit while their output is still read
+		if leftovers = processGroupAlive(cmd); leftovers {
+			terminateProcessGroup(cmd)
+		}
+	case <-ctx.Done():
+		timedOut = ctx.Err() == context.DeadlineExceeded
+		cancelled = !timedOut
+		result.OrphansKilled = se.terminate(cmd, done)
+	}
+
+	// Processes the script left running may keep the pipes open; don't wait
+	// for them longer than the grace period
+	graceEnd := time.Now().Add(se.KillGracePeriod)
+	outputDeadline := time.NewTimer(se.KillGracePeriod)
+	defer outputDeadline.Stop()
+	for _, copied := range []<-chan struct{}{stdoutCopied, stderrCopied} {
+		select {
+		case <-copied:
+		case <-outputDeadline.C:
+			stdoutReader.Close()
+			stderrReader.Close()
+			<-stdoutCopied
+			<-stderrCopied
+		}
+	}
+	if leftovers {
+		se.killLeftovers(cmd, graceEnd)
+		result.OrphansKilled = true
+	}
+	result.Stderr = stderr.buf.String()
+	result.StderrTruncated = stderr.truncated
+	result.Duration = time.Since(start)
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	if timedOut {
+		result.Failure = FailureTimeout
+		return result, fmt.Errorf("script execution timed out after %v", req.Timeout)
+	}
+	if cancelled {
+		result.Failure = FailureExitCode
+		return result, fmt.Errorf("script execution cancelled")
+	}
+	if err != nil {
+		result.Failure = FailureExitCode
+		return result, fmt.Errorf("script execution failed: %v", err)
+	}
+
+	result.Failure = ""
+	result.Output = stdout.String()
+	return result, nil
+}
+
+// writeInlineScript writes an inline script body to a temporary file only the
+// exporter's user can access, and returns its path.
+func writeInlineScript(req ScriptRequest) (string, error) {
+	pattern := fmt.Sprintf("public_exporter-%s-%s-*", req.Cluster, req.Collector)
+	file, err := os.CreateTemp("", strings.ReplaceAll(pattern, string(os.PathSeparator), "_"))
+	if err != nil {
+		return "", fmt.Errorf("failed to create inline script: %v", err)
+	}
+	path := file.Name()
+
+	_, err = file.WriteString(req.Inline)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// Executable, so that script_type exec works with a shebang line
+		err = os.Chmod(path, 0700)
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to write inline script: %v", err)
+	}
+	return path, nil
+}
+
+// scriptEnv builds the environment of a script: the exporter's own environment
+// if inherited, then env_from_file, then env, then the standard PE_ variables.
+func scriptEnv(req ScriptRequest, deadline time.Time) ([]string, error) {
+	vars := make(map[string]string)
+	var order []string
+	set := func(name, value string) {
+		if _, exists := vars[name]; !exists {
+			order = append(order, name)
+		}
+		vars[name] = value
+	}
+
+	if req.InheritEnv {
+		for _, kv := range os.Environ() {
+			if name, value, ok := strings.Cut(kv, "="); ok {
+				set(name, value)
+			}
+		}
+	}
+
+	if req.EnvFromFile != "" {
+		fileVars, err := readEnvFile(req.EnvFromFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range fileVars {
+			set(kv[0], kv[1])
+		}
+	}
+
+	names := make([]string, 0, len(req.Env))
+	for name := range req.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		set(name, req.Env[name])
+	}
+
+	set("PE_CLUSTER", req.Cluster)
+	set("PE_COLLECTOR", req.Collector)
+	set("PE_TIMEOUT", strconv.Itoa(int(req.Timeout/time.Second)))
+	set("PE_DEADLINE", strconv.FormatInt(deadline.Unix(), 10))
+
+	env := make([]string, 0, len(order))
+	for _, name := range order {
+		env = append(env, name+"="+vars[name])
+	}
+	return env, nil
+}
+
+// readEnvFile reads KEY=VALUE lines from a file. Blank lines, comments and an
+// "export " prefix are allowed, and values may be quoted.
+func readEnvFile(path string) ([][2]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env_from_file: %v", err)
+	}
+
+	var vars [][2]string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("env_from_file %s, line %d: expected KEY=VALUE", path, i+1)
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars = append(vars, [2]string{name, value})
+	}
+	return vars, nil
+}
+
+// captureOutput copies r into w in the background and returns a channel that
+// is closed once r is exhausted or closed.
+func captureOutput(r io.Reader, w io.Writer) <-chan struct{} {
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(w, r)
+		close(copied)
+	}()
+	return copied
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the rest.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// killLeftovers waits until the deadline for the processes a script that
+// exited left running in its process group, which got SIGTERM, and kills
+// those still alive with SIGKILL.
+func (se *ScriptExecutor) killLeftovers(cmd *exec.Cmd, deadline time.Time) {
+	for processGroupAlive(cmd) && time.Now().Before(deadline) {
+		time.Sleep(processGroupPollInterval)
+	}
+	if processGroupAlive(cmd) {
+		killProcessGroup(cmd)
+		awaitKilled(cmd)
+	}
+}
+
+// terminate stops a timed-out or cancelled script: its process group gets SIGTERM, and
+// SIGKILL if anything in it is still alive after the grace period. It reports
+// whether processes had to be killed after the script itself had exited.
+func (se *ScriptExecutor) terminate(cmd *exec.Cmd, done <-chan error) bool {
+	deadline := time.Now().Add(se.KillGracePeriod)
+	terminateProcessGroup(cmd)
+
+	select {
+	case <-done:
+	case <-time.After(se.KillGracePeriod):
+		killProcessGroup(cmd)
+		<-done
+		awaitKilled(cmd)
+		return false
+	}
+
+	// The script exited; give the rest of its process group the remaining
+	// grace period before killing it
+	for processGroupAlive(cmd) && time.Now().Before(deadline) {
+		time.Sleep(processGroupPollInterval)
+	}
+	if processGroupAlive(cmd) {
+		killProcessGroup(cmd)
+		awaitKilled(cmd)
+		return true
+	}
+	return false
+}
+
+// awaitKilled gives the processes of a killed process group a moment to exit,
+// so that they are reaped instead of staying zombies.
+func awaitKilled(cmd *exec.Cmd) {
+	for i := 0; i < killedGroupPolls && processGroupAlive(cmd); i++ {
+		time.Sleep(processGroupPollInterval)
+	}
+}
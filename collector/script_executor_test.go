@@ -0,0 +1,250 @@
+//go:build !windows
+
+package collector
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// runInline runs a shell script body with the given timeout
+func runInline(t *testing.T, se *ScriptExecutor, body string, timeout time.Duration) (*ExecResult, error) {
+	t.Helper()
+	return se.ExecuteScript(context.Background(), ScriptRequest{
+		Cluster:     "test",
+		Collector:   t.Name(),
+		Inline:      body,
+		Interpreter: []string{"sh"},
+		Timeout:     timeout,
+	})
+}
+
+func newTestExecutor(grace time.Duration) *ScriptExecutor {
+	return &ScriptExecutor{KillGracePeriod: grace, MaxStderrBytes: 16}
+}
+
+func TestExecuteScriptOutput(t *testing.T) {
+	result, err := runInline(t, newTestExecutor(time.Second),
+		"echo \"up{cluster=\\\"$PE_CLUSTER\\\"} 1\"\necho 'a warning that is too long' >&2\nexit 0", 5*time.Second)
+	if err != nil {
+		t.Fatalf("ExecuteScript() = %v", err)
+	}
+	if want := "up{cluster=\"test\"} 1\n"; result.Output != want {
+		t.Errorf("output = %q, want %q", result.Output, want)
+	}
+	if result.Stderr != "a warning that i" || !result.StderrTruncated {
+		t.Errorf("stderr = %q (truncated %v), want the first 16 bytes", result.Stderr, result.StderrTruncated)
+	}
+	if result.ExitCode != 0 || result.Failure != "" || result.OrphansKilled {
+		t.Errorf("result = %+v, want a clean exit", result)
+	}
+}
+
+func TestExecuteScriptExitCode(t *testing.T) {
+	result, err := runInline(t, newTestExecutor(time.Second), "echo up 1\nexit 3", 5*time.Second)
+	if err == nil || result.ExitCode != 3 || result.Failure != FailureExitCode {
+		t.Errorf("result = %+v, %v, want exit code 3", result, err)
+	}
+	if result.Output != "" {
+		t.Errorf("output = %q, want none from a failed script", result.Output)
+	}
+}
+
+func TestExecuteScriptTimeoutKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	start := time.Now()
+	result, err := runInline(t, newTestExecutor(5*time.Second),
+		"sleep 30 >/dev/null 2>&1 &\necho $! > "+pidFile+"\nsleep 30", time.Second)
+	elapsed := time.Since(start)
+
+	if err == nil || result.Failure != FailureTimeout {
+		t.Fatalf("result = %+v, %v, want a timeout", result, err)
+	}
+	// Both processes exit on SIGTERM, so the grace period is not used up
+	if elapsed > 3*time.Second {
+		t.Errorf("execution took %v, want about the timeout of 1s", elapsed)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processRunning(pid) {
+		t.Errorf("the script's child %d is still running", pid)
+	}
+}
+
+// processRunning reports whether a process exists and is not a zombie. Killed
+// orphans are re-parented to init, which may take its time to reap them.
+func processRunning(pid int) bool {
+	if syscall.Kill(pid, 0) == syscall.ESRCH {
+		return false
+	}
+	state, err := exec.Command("ps", "-o", "stat=", "-p", strconv.Itoa(pid)).Output()
+	return err == nil && !strings.HasPrefix(strings.TrimSpace(string(state)), "Z")
+}
+
+func TestExecuteScriptKillsAfterGracePeriod(t *testing.T) {
+	start := time.Now()
+	result, err := runInline(t, newTestExecutor(time.Second), "trap '' TERM\nsleep 30", time.Second)
+	elapsed := time.Since(start)
+
+	if err == nil || result.Failure != FailureTimeout || result.ExitCode != -1 {
+		t.Errorf("result = %+v, %v, want a timeout and no exit code", result, err)
+	}
+	if elapsed < 2*time.Second || elapsed > 4*time.Second {
+		t.Errorf("execution took %v, want the timeout and the grace period, 2s", elapsed)
+	}
+}
+
+func TestExecuteScriptGivesLeftoversGracePeriod(t *testing.T) {
+	// The background child holds stdout and cleans up on SIGTERM, which it
+	// must get as soon as the script exits, not after the grace period
+	marker := filepath.Join(t.TempDir(), "cleaned-up")
+	start := time.Now()
+	result, err := runInline(t, newTestExecutor(2*time.Second),
+		"(trap 'sleep 0.3; touch "+marker+"; exit 0' TERM; sleep 30) &\necho up 1", 5*time.Second)
+	elapsed := time.Since(start)
+
+	if err != nil || result.Output != "up 1\n" || !result.OrphansKilled {
+		t.Fatalf("result = %+v, %v, want the output and the leftover reported", result, err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("the leftover did not get to clean up: %v", err)
+	}
+	if elapsed > 1500*time.Millisecond {
+		t.Errorf("execution took %v, want the leftover to be gone well within the 2s grace period", elapsed)
+	}
+}
+
+func TestExecuteScriptCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	result, err := newTestExecutor(time.Second).ExecuteScript(ctx, ScriptRequest{
+		Inline:      "sleep 30",
+		Interpreter: []string{"sh"},
+		Timeout:     10 * time.Second,
+	})
+	if err == nil || result.Failure != FailureExitCode || result.Duration > 2*time.Second {
+		t.Errorf("result = %+v, %v, want a cancelled execution", result, err)
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "env")
+	content := strings.Join([]string{
+		"# database settings",
+		"",
+		"DB_HOST=db.example.com",
+		"  export DB_PORT = 5432  ",
+		`DB_NAME="metrics db"`,
+		"DB_USER='exporter'",
+		"DB_OPTS=sslmode=require",
+		`DB_QUOTE="unbalanced`,
+		"DB_EMPTY=",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	vars, err := readEnvFile(path)
+	if err != nil {
+		t.Fatalf("readEnvFile() = %v", err)
+	}
+	want := [][2]string{
+		{"DB_HOST", "db.example.com"},
+		{"DB_PORT", "5432"},
+		{"DB_NAME", "metrics db"},
+		{"DB_USER", "exporter"},
+		{"DB_OPTS", "sslmode=require"},
+		{"DB_QUOTE", `"unbalanced`},
+		{"DB_EMPTY", ""},
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("readEnvFile() = %q, want %q", vars, want)
+	}
+}
+
+func TestReadEnvFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid")
+	if err := os.WriteFile(invalid, []byte("A=1\n# comment\nnot a variable\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{filepath.Join(dir, "missing"), "failed to read env_from_file"},
+		{invalid, "line 3: expected KEY=VALUE"},
+	}
+	for _, tt := range tests {
+		if _, err := readEnvFile(tt.path); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("readEnvFile(%s) = %v, want an error containing %q", tt.path, err, tt.want)
+		}
+	}
+}
+
+func TestScriptEnv(t *testing.T) {
+	t.Setenv("PE_TEST_INHERITED", "yes")
+	envFile := filepath.Join(t.TempDir(), "env")
+	if err := os.WriteFile(envFile, []byte("FROM_FILE=file\nOVERRIDDEN=file\nPE_CLUSTER=file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	req := ScriptRequest{
+		Cluster:     "prod",
+		Collector:   "disk",
+		Env:         map[string]string{"OVERRIDDEN": "env", "B": "2", "A": "1"},
+		EnvFromFile: envFile,
+		Timeout:     30 * time.Second,
+	}
+	deadline := time.Unix(1700000000, 0)
+
+	// Without the exporter's environment, the script only gets env_from_file,
+	// then env, then the standard variables, each overriding the ones before
+	env, err := scriptEnv(req, deadline)
+	if err != nil {
+		t.Fatalf("scriptEnv() = %v", err)
+	}
+	want := []string{
+		"FROM_FILE=file",
+		"OVERRIDDEN=env",
+		"PE_CLUSTER=prod",
+		"A=1",
+		"B=2",
+		"PE_COLLECTOR=disk",
+		"PE_TIMEOUT=30",
+		"PE_DEADLINE=1700000000",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("scriptEnv() = %q, want %q", env, want)
+	}
+
+	req.InheritEnv = true
+	env, err = scriptEnv(req, deadline)
+	if err != nil {
+		t.Fatalf("scriptEnv() = %v", err)
+	}
+	if !slices.Contains(env, "PE_TEST_INHERITED=yes") || !slices.Contains(env, "OVERRIDDEN=env") {
+		t.Errorf("scriptEnv() with inherit_env = %q, want the exporter's environment and env", env)
+	}
+
+	req.EnvFromFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := scriptEnv(req, deadline); err == nil {
+		t.Error("scriptEnv() with a missing env_from_file succeeded")
+	}
+}
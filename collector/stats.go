@@ -0,0 +1,94 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file keeps the execution statistics of a collector: how long its
+// runs take, how many failed and why, and what the last run returned.
+
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// DurationBuckets are the upper bounds, in seconds, of the buckets of the
+// execution duration histogram
+var DurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// ExecutionStats is a snapshot of the executions of a collector
+type ExecutionStats struct {
+	Runs           uint64
+	Failures       map[string]uint64 // by reason, see FailureReasons
+	DurationCounts []uint64          // cumulative runs per bucket of DurationBuckets
+	DurationSum    float64           // seconds
+	LastExitCode   int
+	LastSuccess    time.Time // zero if no run succeeded yet
+	OutputBytes    int       // size of the last run's output
+}
+
+// executionStats accumulates the execution statistics of a collector
+type executionStats struct {
+	mu             sync.Mutex
+	runs           uint64
+	failures       map[string]uint64
+	durationCounts []uint64 // per bucket, not cumulative
+	durationSum    float64
+	lastExitCode   int
+	lastSuccess    time.Time
+	outputBytes    int
+}
+
+func newExecutionStats() *executionStats {
+	return &executionStats{
+		failures:       make(map[string]uint64),
+		durationCounts: make([]uint64, len(DurationBuckets)),
+	}
+}
+
+// record counts a run of the collector
+func (s *executionStats) record(result *ExecResult, output *CollectorOutput) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs++
+	seconds := result.Duration.Seconds()
+	s.durationSum += seconds
+	for i, bound := range DurationBuckets {
+		if seconds <= bound {
+			s.durationCounts[i]++
+			break
+		}
+	}
+	s.lastExitCode = result.ExitCode
+	s.outputBytes = len(result.Output)
+	if output.Failure != "" {
+		s.failures[output.Failure]++
+	} else {
+		s.lastSuccess = output.LastSeen
+	}
+}
+
+// snapshot returns the statistics collected so far
+func (s *executionStats) snapshot() ExecutionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := ExecutionStats{
+		Runs:           s.runs,
+		Failures:       make(map[string]uint64, len(FailureReasons)),
+		DurationCounts: make([]uint64, len(DurationBuckets)),
+		DurationSum:    s.durationSum,
+		LastExitCode:   s.lastExitCode,
+		LastSuccess:    s.lastSuccess,
+		OutputBytes:    s.outputBytes,
+	}
+	for _, reason := range FailureReasons {
+		stats.Failures[reason] = s.failures[reason]
+	}
+	var cumulative uint64
+	for i, count := range s.durationCounts {
+		cumulative += count
+		stats.DurationCounts[i] = cumulative
+	}
+	return stats
+}
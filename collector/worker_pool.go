@@ -0,0 +1,95 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file implements the bounded worker pool that limits how many scripts
+// run at the same time, globally and optionally per cluster.
+
+package collector
+
+import (
+	"context"
+	"public_exporter/config"
+	"sync/atomic"
+)
+
+// workerPool hands out execution slots. A script needs a slot from its
+// cluster's pool (if the cluster has a limit) and from the global pool.
+type workerPool struct {
+	global   chan struct{}
+	clusters map[string]chan struct{}
+	queued   int64
+	running  int64
+}
+
+func newWorkerPool(cfg *config.Config) *workerPool {
+	pool := &workerPool{
+		global:   make(chan struct{}, cfg.Global.MaxConcurrentScripts),
+		clusters: make(map[string]chan struct{}),
+	}
+	for clusterName, clusterCfg := range cfg.Clusters {
+		if clusterCfg.MaxConcurrentScripts > 0 {
+			pool.clusters[clusterName] = make(chan struct{}, clusterCfg.MaxConcurrentScripts)
+		}
+	}
+	return pool
+}
+
+// hasLimits reports whether the pool enforces the limits of cfg, in which
+// case it can be kept across a configuration reload.
+func (p *workerPool) hasLimits(cfg *config.Config) bool {
+	if cap(p.global) != cfg.Global.MaxConcurrentScripts {
+		return false
+	}
+	limited := 0
+	for clusterName, clusterCfg := range cfg.Clusters {
+		if clusterCfg.MaxConcurrentScripts > 0 {
+			limited++
+			if cap(p.clusters[clusterName]) != clusterCfg.MaxConcurrentScripts {
+				return false
+			}
+		}
+	}
+	return limited == len(p.clusters)
+}
+
+// acquire blocks until a slot is free for a script of the given cluster and
+// returns a function releasing it. Slots are always taken cluster first, so
+// waiting for a busy cluster never holds a global slot.
+func (p *workerPool) acquire(ctx context.Context, clusterName string) (func(), error) {
+	atomic.AddInt64(&p.queued, 1)
+	defer atomic.AddInt64(&p.queued, -1)
+
+	clusterSlots := p.clusters[clusterName]
+	if clusterSlots != nil {
+		select {
+		case clusterSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case p.global <- struct{}{}:
+	case <-ctx.Done():
+		if clusterSlots != nil {
+			<-clusterSlots
+		}
+		return nil, ctx.Err()
+	}
+
+	atomic.AddInt64(&p.running, 1)
+	return func() {
+		atomic.AddInt64(&p.running, -1)
+		<-p.global
+		if clusterSlots != nil {
+			<-clusterSlots
+		}
+	}, nil
+}
+
+// stats returns the number of scripts waiting for a slot and running.
+func (p *workerPool) stats() (queued, running int) {
+	return int(atomic.LoadInt64(&p.queued)), int(atomic.LoadInt64(&p.running))
+}
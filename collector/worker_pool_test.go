@@ -0,0 +1,114 @@
+package collector
+
+import (
+	"context"
+	"public_exporter/config"
+	"testing"
+	"time"
+)
+
+func poolConfig(global int, clusters map[string]int) *config.Config {
+	cfg := &config.Config{Clusters: make(map[string]config.ClusterConfig)}
+	cfg.Global.MaxConcurrentScripts = global
+	for name, limit := range clusters {
+		cfg.Clusters[name] = config.ClusterConfig{MaxConcurrentScripts: limit}
+	}
+	return cfg
+}
+
+// tryAcquire takes a slot if one is free right away
+func tryAcquire(t *testing.T, pool *workerPool, cluster string) func() {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	release, err := pool.acquire(ctx, cluster)
+	if err != nil {
+		return nil
+	}
+	return release
+}
+
+func TestWorkerPoolLimits(t *testing.T) {
+	pool := newWorkerPool(poolConfig(3, map[string]int{"small": 1}))
+
+	first := tryAcquire(t, pool, "small")
+	if first == nil {
+		t.Fatal("no slot for the first script of cluster small")
+	}
+	if tryAcquire(t, pool, "small") != nil {
+		t.Error("cluster small ran two scripts at once")
+	}
+
+	// Other clusters are only limited by the global pool
+	second, third := tryAcquire(t, pool, "big"), tryAcquire(t, pool, "big")
+	if second == nil || third == nil {
+		t.Fatal("no slots left for cluster big")
+	}
+	if tryAcquire(t, pool, "big") != nil {
+		t.Error("more scripts than max_concurrent_scripts ran at once")
+	}
+	if queued, running := pool.stats(); queued != 0 || running != 3 {
+		t.Errorf("stats = %d queued, %d running, want 0 and 3", queued, running)
+	}
+
+	// A released slot can be taken again
+	second()
+	if release := tryAcquire(t, pool, "big"); release == nil {
+		t.Error("the released slot was not reused")
+	} else {
+		release()
+	}
+	first()
+	third()
+	if queued, running := pool.stats(); queued != 0 || running != 0 {
+		t.Errorf("stats = %d queued, %d running after release, want 0 and 0", queued, running)
+	}
+}
+
+func TestWorkerPoolBusyClusterHoldsNoGlobalSlot(t *testing.T) {
+	pool := newWorkerPool(poolConfig(2, map[string]int{"small": 1}))
+	release := tryAcquire(t, pool, "small")
+	if release == nil {
+		t.Fatal("no slot for cluster small")
+	}
+
+	// A script waiting for its busy cluster leaves the second global slot
+	// to other clusters
+	waiting := make(chan func())
+	go func() {
+		release, _ := pool.acquire(context.Background(), "small")
+		waiting <- release
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if queued, _ := pool.stats(); queued != 1 {
+		t.Errorf("%d scripts queued, want 1", queued)
+	}
+	other := tryAcquire(t, pool, "other")
+	if other == nil {
+		t.Fatal("the waiting script holds a global slot")
+	}
+	other()
+
+	release()
+	(<-waiting)()
+}
+
+func TestWorkerPoolHasLimits(t *testing.T) {
+	pool := newWorkerPool(poolConfig(3, map[string]int{"a": 1, "b": 0}))
+	tests := []struct {
+		cfg  *config.Config
+		want bool
+	}{
+		{poolConfig(3, map[string]int{"a": 1, "b": 0}), true},
+		{poolConfig(3, map[string]int{"a": 1}), true},
+		{poolConfig(4, map[string]int{"a": 1}), false},
+		{poolConfig(3, map[string]int{"a": 2}), false},
+		{poolConfig(3, map[string]int{"a": 1, "b": 1}), false},
+		{poolConfig(3, nil), false},
+	}
+	for i, tt := range tests {
+		if got := pool.hasLimits(tt.cfg); got != tt.want {
+			t.Errorf("%d: hasLimits() = %v, want %v", i, got, tt.want)
+		}
+	}
+}
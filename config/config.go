@@ -10,20 +10,87 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/lestrrat-go/file-rotatelogs"
-	"gopkg.in/yaml.v2"
-	"io/ioutil"
+	"gopkg.in/yaml.v3"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 )
 
+// Label conflict modes, applied when a script already sets a label the
+// exporter injects.
+const (
+	LabelConflictExported  = "exported"  // rename the script's label to exported_<name>
+	LabelConflictOverwrite = "overwrite" // replace the script's value
+	LabelConflictKeep      = "keep"      // keep the script's value
+)
+
+// LabelConflictModes are the valid label_conflict settings.
+var LabelConflictModes = []string{LabelConflictExported, LabelConflictOverwrite, LabelConflictKeep}
+
+// DefaultTypes are the valid default_type settings.
+var DefaultTypes = []string{"counter", "gauge", "untyped"}
+
+// Execution modes of a collector.
+const (
+	ModeInterval = "interval"  // run every interval seconds in the background
+	ModeOnScrape = "on_scrape" // run when /metrics is scraped
+)
+
+// Modes are the valid mode settings.
+var Modes = []string{ModeInterval, ModeOnScrape}
+
+// What happens to the output of a collector that is older than max_age.
+const (
+	StaleDrop   = "drop"   // stop serving the output
+	StaleLabel  = "label"  // serve the output with a stale="true" label
+	StaleMetric = "metric" // stop serving the output and set collector_output_stale
+)
+
+// StaleActions are the valid stale_action settings.
+var StaleActions = []string{StaleDrop, StaleLabel, StaleMetric}
+
+// Script types that are not looked up in the interpreters map.
+const (
+	ScriptTypeExec   = "exec"   // run the script file directly
+	ScriptTypeCustom = "custom" // run it with the collector's interpreter
+)
+
+// builtinInterpreters are the interpreters available without configuration.
+var builtinInterpreters = map[string]string{
+	"python":  "python3",
+	"python2": "python2",
+	"python3": "python3",
+	"shell":   "bash",
+}
+
+// identifierRE matches valid label and environment variable names.
+var identifierRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// labelNameRE matches the names of static labels: identifiers not starting
+// with "__", which Prometheus reserves for internal use.
+var labelNameRE = regexp.MustCompile(`^(_|[a-zA-Z][a-zA-Z0-9_]*|_[a-zA-Z0-9][a-zA-Z0-9_]*)$`)
+
+// envNameRE matches the names of environment variables a configuration may
+// set: identifiers not starting with "PE_", which the exporter sets itself.
+var envNameRE = regexp.MustCompile(`^([a-zA-OQ-Z_][a-zA-Z0-9_]*|P([a-zA-DF-Z0-9_][a-zA-Z0-9_]*)?|PE([a-zA-Z0-9][a-zA-Z0-9_]*)?)$`)
+
 // Config holds the global configuration.
 type Config struct {
-	Global  GlobalConfig              `yaml:"global"`
-	Clusters map[string]ClusterConfig `yaml:"clusters"`
+	Include   []string                   `yaml:"include" expand:"false"` // glob patterns of files with more clusters, expanded when loading
+	Global    GlobalConfig               `yaml:"global"`
+	Templates map[string]CollectorConfig `yaml:"templates"` // collector settings used with extends
+	Clusters  map[string]ClusterConfig   `yaml:"clusters"`
+
+	Files  []string `yaml:"-"` // the files the configuration was loaded from
+	Merged []byte   `yaml:"-"` // the merged configuration, before expansion and defaults
 }
 
 // GlobalConfig holds global configuration settings.
@@ -33,51 +100,140 @@ type GlobalConfig struct {
 	LogMaxAge           int    `yaml:"log_max_age"`
 	LogRotationTime     int    `yaml:"log_rotation_time"`
 	DefaultScrapeInterval int  `yaml:"default_scrape_interval"`
+	DefaultTimeout      int    `yaml:"default_timeout"`
 	HTTPPort            int    `yaml:"http_port"`
 	HTTPTimeout         int    `yaml:"http_timeout"`
+	MaxConcurrentScripts int   `yaml:"max_concurrent_scripts"`
+	KillGracePeriod     int    `yaml:"kill_grace_period"`
+	StderrLogLevel      string `yaml:"stderr_log_level"`
+	MaxStderrBytes      int    `yaml:"max_stderr_bytes"`
+	Interpreters        map[string]string `yaml:"interpreters"` // script_type -> interpreter command line
+	WatchConfig         bool   `yaml:"watch_config"`      // reload when the config file changes
+	WatchScripts        bool   `yaml:"watch_scripts"`     // re-run collectors when their script changes
+	WatchDebounceMs     int    `yaml:"watch_debounce_ms"` // quiet time before reacting to file changes
+	StrictExpansion     bool   `yaml:"strict_expansion"`  // fail on undefined ${VAR} references
+	Splay               bool   `yaml:"splay"`             // offset interval runs by a hash of host and collector
+	StartupSpread       int    `yaml:"startup_spread"`    // seconds the first runs are spread over at startup
 }
 
-// ClusterConfig represents the configuration for a cluster.
+// ClusterConfig represents the configuration for a cluster. Its collector
+// settings, such as env or workdir, are a shorthand for setting them in
+// Defaults.
 type ClusterConfig struct {
-	Enabled    bool                       `yaml:"enabled"`
-	Collectors map[string]CollectorConfig `yaml:"collectors"`
+	Enabled              bool                       `yaml:"enabled"`
+	MaxConcurrentScripts int                        `yaml:"max_concurrent_scripts"` // 0 means only the global limit applies
+	InjectLabels         *bool                      `yaml:"inject_labels"`          // add cluster/collector labels to every series
+	LabelConflict        string                     `yaml:"label_conflict"`         // exported, overwrite or keep
+	Labels               map[string]string          `yaml:"labels"`                 // static labels for all collectors of the cluster
+	Args                 []string                   `yaml:"args"`                   // default script arguments
+	Env                  map[string]string          `yaml:"env"`                    // environment for all collectors of the cluster
+	EnvFromFile          string                     `yaml:"env_from_file"`          // default KEY=VALUE file
+	Workdir              string                     `yaml:"workdir"`                // default working directory
+	InheritEnv           *bool                      `yaml:"inherit_env"`            // default: true
+	Defaults             CollectorConfig            `yaml:"defaults"`               // settings inherited by all collectors
+	Collectors           map[string]CollectorConfig `yaml:"collectors"`
 }
 
 // CollectorConfig holds the configuration for a collector.
 type CollectorConfig struct {
-	Enabled    bool   `yaml:"enabled"`
-	Interval   int    `yaml:"interval"`
-	Timeout    int    `yaml:"timeout"`
-	ScriptPath string `yaml:"script_path"`
-	ScriptType string `yaml:"script_type"`
+	Enabled              bool              `yaml:"enabled" inherit:"false"`
+	Extends              string            `yaml:"extends" inherit:"false"` // template to inherit settings from
+	Mode                 string            `yaml:"mode"`                    // interval (default) or on_scrape
+	Interval             int               `yaml:"interval"`
+	MinInterval          int               `yaml:"min_interval"`           // on_scrape: seconds a result is reused for
+	Schedule             string            `yaml:"schedule"`               // cron expression, instead of interval
+	Timezone             string            `yaml:"timezone"`               // of schedule and active_windows, default local
+	ActiveWindows        []TimeWindow      `yaml:"active_windows"`         // times the collector may run, any time if empty
+	Jitter               int               `yaml:"jitter"`                 // random delay of up to this many seconds per run
+	Retries              int               `yaml:"retries"`                // retries of a failed run within one cycle
+	RetryBackoff         int               `yaml:"retry_backoff"`          // seconds before the first retry, doubled for each one
+	BreakerThreshold     int               `yaml:"breaker_threshold"`      // consecutive failures that open the circuit breaker
+	BreakerProbeInterval int               `yaml:"breaker_probe_interval"` // seconds between runs while the breaker is open
+	MaxAge               int               `yaml:"max_age"`                // seconds after which the output is stale, 0 for never
+	StaleAction          string            `yaml:"stale_action"`           // drop (default), label or metric
+	Timeout              int               `yaml:"timeout"`
+	ScriptPath           string            `yaml:"script_path"`
+	Inline               string            `yaml:"inline" expand:"false"` // script body, instead of script_path
+	ScriptType           string            `yaml:"script_type"`
+	Interpreter          string            `yaml:"interpreter"`    // command line for script_type custom
+	Args                 []string          `yaml:"args"`           // passed to the script, defaults to the cluster args
+	Env                  map[string]string `yaml:"env"`            // merged over the cluster env
+	EnvFromFile          string            `yaml:"env_from_file"`  // KEY=VALUE file read before each execution
+	Workdir              string            `yaml:"workdir"`        // working directory, defaults to the cluster's
+	InheritEnv           *bool             `yaml:"inherit_env"`    // pass the exporter's environment to the script
+	InjectLabels         *bool             `yaml:"inject_labels"`  // defaults to the cluster setting
+	LabelConflict        string            `yaml:"label_conflict"` // defaults to the cluster setting
+	Labels               map[string]string `yaml:"labels"`         // merged over the cluster labels
+	DefaultType          string            `yaml:"default_type"`   // type for families the script does not declare
+	DefaultHelp          string            `yaml:"default_help"`   // help for families the script does not describe
+
+	present map[string]bool // keys set in the configuration, even to zero values
 }
 
-// LoadConfig loads the YAML configuration from the specified path.
+// LoadConfig loads the YAML configuration from the specified path. An
+// invalid configuration is reported with all its problems, as ConfigErrors.
 func LoadConfig(path string) (*Config, error) {
-	data, err := ioutil.ReadFile(path)
+	doc, err := loadDocument(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+		return nil, configError(err)
 	}
 	
+	// Find unknown keys and wrongly typed values. Decoding skips them, so
+	// the rest of the configuration is still checked and all problems are
+	// reported together.
+	positions, errs := doc.check()
+	
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if err := doc.root.Decode(&cfg); err != nil && len(errs) == 0 {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
-
-	// Set default values and validate
-	if err := cfg.setDefaults(); err != nil {
-		return nil, fmt.Errorf("failed to set defaults: %w", err)
+	cfg.Files = doc.files
+	cfg.Include = doc.includes
+	var merged bytes.Buffer
+	encoder := yaml.NewEncoder(&merged)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc.root); err != nil {
+		return nil, fmt.Errorf("failed to render merged config: %w", err)
 	}
-	
-	if err := cfg.validate(); err != nil {
-		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	encoder.Close()
+	cfg.Merged = merged.Bytes()
+
+	// Expand, set default values and validate. Settings that were already
+	// reported are not reported again for the value they were left with.
+	errs = append(errs, expandConfig(&cfg)...)
+	errs = append(errs, cfg.setDefaults()...)
+	errs = append(errs, cfg.validate().excluding(errs)...)
+	if len(errs) > 0 {
+		errs.locate(positions, path)
+		return nil, configError(errs)
 	}
 
 	return &cfg, nil
 }
 
+// configError wraps the problems found in a configuration
+func configError(err error) error {
+	if errs, ok := err.(ConfigErrors); ok {
+		if len(errs) == 1 {
+			return fmt.Errorf("configuration validation failed: %w", errs)
+		}
+		return fmt.Errorf("configuration validation failed with %d errors:\n%w", len(errs), errs)
+	}
+	return err
+}
+
+// DefaultConfig returns a configuration without clusters, with all global
+// settings at their defaults.
+func DefaultConfig() *Config {
+	cfg := &Config{}
+	cfg.setDefaults()
+	return cfg
+}
+
 // setDefaults sets default values for configuration fields.
-func (c *Config) setDefaults() error {
+func (c *Config) setDefaults() ConfigErrors {
+	var errs ConfigErrors
+
 	// Global defaults
 	if c.Global.LogLevel == "" {
 		c.Global.LogLevel = "info"
@@ -91,21 +247,92 @@ func (c *Config) setDefaults() error {
 	if c.Global.DefaultScrapeInterval == 0 {
 		c.Global.DefaultScrapeInterval = 60 // Default: 60 seconds
 	}
+	if c.Global.DefaultTimeout == 0 {
+		c.Global.DefaultTimeout = 30 // Default: 30 seconds
+	}
 	if c.Global.HTTPPort == 0 {
 		c.Global.HTTPPort = 5535 // Default: 5535
 	}
 	if c.Global.HTTPTimeout == 0 {
 		c.Global.HTTPTimeout = 30 // Default: 30 seconds
 	}
+	if c.Global.MaxConcurrentScripts == 0 {
+		c.Global.MaxConcurrentScripts = 10 // Default: 10 scripts at a time
+	}
+	if c.Global.KillGracePeriod == 0 {
+		c.Global.KillGracePeriod = 5 // Default: 5 seconds
+	}
+	if c.Global.StderrLogLevel == "" {
+		c.Global.StderrLogLevel = "warn"
+	}
+	if c.Global.MaxStderrBytes == 0 {
+		c.Global.MaxStderrBytes = 4096 // Default: 4 KiB
+	}
+	if c.Global.WatchDebounceMs == 0 {
+		c.Global.WatchDebounceMs = 500 // Default: 500 milliseconds
+	}
+	if c.Global.Interpreters == nil {
+		c.Global.Interpreters = make(map[string]string)
+	}
+	for scriptType, command := range builtinInterpreters {
+		if _, ok := c.Global.Interpreters[scriptType]; !ok {
+			c.Global.Interpreters[scriptType] = command
+		}
+	}
+	
+	for templateName, template := range c.Templates {
+		if _, err := c.resolveTemplates(template); err != nil {
+			errs.add("templates."+templateName+".extends", "%v", err)
+		}
+		errs = append(errs, checkInheritable("templates."+templateName, template)...)
+	}
 	
 	// Collector defaults
 	for clusterName, clusterCfg := range c.Clusters {
+		clusterPath := "clusters." + clusterName
+		defaults, err := c.resolveTemplates(clusterCfg.Defaults)
+		if err != nil {
+			errs.add(clusterPath+".defaults.extends", "%v", err)
+		}
+		errs = append(errs, checkInheritable(clusterPath+".defaults", clusterCfg.Defaults)...)
+		defaults, clusterErrs := applyClusterSettings(clusterPath, clusterCfg, defaults)
+		errs = append(errs, clusterErrs...)
 		for collectorName, collectorCfg := range clusterCfg.Collectors {
+			// Settings come from the collector, its templates, the cluster
+			// defaults and finally the global defaults
+			collectorCfg, err := c.resolveTemplates(collectorCfg)
+			if err != nil {
+				errs.add(clusterPath+".collectors."+collectorName+".extends", "%v", err)
+			}
+			inheritSettings(&collectorCfg, defaults)
+			if collectorCfg.Mode == "" {
+				collectorCfg.Mode = ModeInterval
+			}
 			if collectorCfg.Interval == 0 {
 				collectorCfg.Interval = c.Global.DefaultScrapeInterval
 			}
 			if collectorCfg.Timeout == 0 {
-				collectorCfg.Timeout = 30 // Default: 30 seconds
+				collectorCfg.Timeout = c.Global.DefaultTimeout
+			}
+			if collectorCfg.RetryBackoff == 0 {
+				collectorCfg.RetryBackoff = 1 // Default: 1 second
+			}
+			if collectorCfg.BreakerProbeInterval == 0 && collectorCfg.Interval > 0 {
+				collectorCfg.BreakerProbeInterval = 5 * collectorCfg.Interval
+			}
+			if collectorCfg.StaleAction == "" {
+				collectorCfg.StaleAction = StaleDrop
+			}
+			if collectorCfg.InjectLabels == nil {
+				injectLabels := false
+				collectorCfg.InjectLabels = &injectLabels
+			}
+			if collectorCfg.LabelConflict == "" {
+				collectorCfg.LabelConflict = LabelConflictExported
+			}
+			if collectorCfg.InheritEnv == nil {
+				inheritEnv := true
+				collectorCfg.InheritEnv = &inheritEnv
 			}
 			// Update the collector config in the map
 			clusterCfg.Collectors[collectorName] = collectorCfg
@@ -113,91 +340,282 @@ func (c *Config) setDefaults() error {
 		c.Clusters[clusterName] = clusterCfg
 	}
 	
-	return nil
+	return errs
+}
+
+// mergeMaps returns the entries of base overridden by those of override.
+func mergeMaps(base, override map[string]string) map[string]string {
+	if len(base) == 0 {
+		return override
+	}
+	merged := make(map[string]string, len(base)+len(override))
+	for name, value := range base {
+		merged[name] = value
+	}
+	for name, value := range override {
+		merged[name] = value
+	}
+	return merged
 }
 
-// validate validates the configuration.
-func (c *Config) validate() error {
+// validate validates the configuration and returns all problems found.
+func (c *Config) validate() ConfigErrors {
+	var errs ConfigErrors
+	
 	// Validate global settings
 	if c.Global.LogFile == "" {
-		return fmt.Errorf("global.log_file is required")
+		errs.add("global.log_file", "is required")
+	}
+	
+	if _, err := logrus.ParseLevel(c.Global.LogLevel); err != nil {
+		errs.add("global.log_level", "%v", err)
 	}
 	
 	if c.Global.LogMaxAge <= 0 {
-		return fmt.Errorf("global.log_max_age must be positive, got %d", c.Global.LogMaxAge)
+		errs.add("global.log_max_age", "must be positive, got %d", c.Global.LogMaxAge)
 	}
 	
 	if c.Global.LogRotationTime <= 0 {
-		return fmt.Errorf("global.log_rotation_time must be positive, got %d", c.Global.LogRotationTime)
+		errs.add("global.log_rotation_time", "must be positive, got %d", c.Global.LogRotationTime)
 	}
 	
 	if c.Global.DefaultScrapeInterval <= 0 {
-		return fmt.Errorf("global.default_scrape_interval must be positive, got %d", c.Global.DefaultScrapeInterval)
+		errs.add("global.default_scrape_interval", "must be positive, got %d", c.Global.DefaultScrapeInterval)
+	}
+	
+	if c.Global.DefaultTimeout <= 0 {
+		errs.add("global.default_timeout", "must be positive, got %d", c.Global.DefaultTimeout)
 	}
 	
 	if c.Global.HTTPPort <= 0 || c.Global.HTTPPort > 65535 {
-		return fmt.Errorf("global.http_port must be between 1 and 65535, got %d", c.Global.HTTPPort)
+		errs.add("global.http_port", "must be between 1 and 65535, got %d", c.Global.HTTPPort)
 	}
 	
 	if c.Global.HTTPTimeout <= 0 {
-		return fmt.Errorf("global.http_timeout must be positive, got %d", c.Global.HTTPTimeout)
+		errs.add("global.http_timeout", "must be positive, got %d", c.Global.HTTPTimeout)
+	}
+	
+	if c.Global.MaxConcurrentScripts <= 0 {
+		errs.add("global.max_concurrent_scripts", "must be positive, got %d", c.Global.MaxConcurrentScripts)
+	}
+	
+	if c.Global.KillGracePeriod <= 0 {
+		errs.add("global.kill_grace_period", "must be positive, got %d", c.Global.KillGracePeriod)
+	}
+	
+	if _, err := logrus.ParseLevel(c.Global.StderrLogLevel); err != nil {
+		errs.add("global.stderr_log_level", "%v", err)
+	}
+	
+	if c.Global.MaxStderrBytes <= 0 {
+		errs.add("global.max_stderr_bytes", "must be positive, got %d", c.Global.MaxStderrBytes)
+	}
+	
+	if c.Global.WatchDebounceMs <= 0 {
+		errs.add("global.watch_debounce_ms", "must be positive, got %d", c.Global.WatchDebounceMs)
+	}
+	
+	if c.Global.StartupSpread < 0 {
+		errs.add("global.startup_spread", "must not be negative, got %d", c.Global.StartupSpread)
+	}
+	
+	for scriptType, command := range c.Global.Interpreters {
+		if scriptType == ScriptTypeExec || scriptType == ScriptTypeCustom {
+			errs.add("global.interpreters."+scriptType, "%s is a reserved script type", scriptType)
+		} else if len(strings.Fields(command)) == 0 {
+			errs.add("global.interpreters."+scriptType, "empty command")
+		}
 	}
 	
 	// Validate clusters and collectors
 	if len(c.Clusters) == 0 {
-		return fmt.Errorf("at least one cluster must be configured")
+		errs.add("clusters", "at least one cluster must be configured")
 	}
 	
 	for clusterName, clusterCfg := range c.Clusters {
+		clusterPath := "clusters." + clusterName
+		if clusterCfg.MaxConcurrentScripts < 0 {
+			errs.add(clusterPath+".max_concurrent_scripts", "must not be negative, got %d", clusterCfg.MaxConcurrentScripts)
+		}
+		
 		if clusterCfg.Enabled {
 			if len(clusterCfg.Collectors) == 0 {
-				return fmt.Errorf("cluster %s has no collectors configured", clusterName)
+				errs.add(clusterPath, "no collectors configured")
 			}
 			
 			for collectorName, collectorCfg := range clusterCfg.Collectors {
 				if collectorCfg.Enabled {
-					if err := validateCollectorConfig(collectorName, collectorCfg); err != nil {
-						return fmt.Errorf("cluster %s, collector %s: %w", clusterName, collectorName, err)
-					}
+					errs = append(errs, validateCollectorConfig(clusterPath+".collectors."+collectorName, collectorCfg, c.Global.Interpreters)...)
 				}
 			}
 		}
 	}
 	
-	return nil
+	return errs
 }
 
 // validateCollectorConfig validates individual collector configuration.
-func validateCollectorConfig(name string, cfg CollectorConfig) error {
+func validateCollectorConfig(path string, cfg CollectorConfig, interpreters map[string]string) ConfigErrors {
+	var errs ConfigErrors
+	
 	if cfg.Interval <= 0 {
-		return fmt.Errorf("interval must be positive, got %d", cfg.Interval)
+		errs.add(path+".interval", "must be positive, got %d", cfg.Interval)
 	}
 	
 	if cfg.Timeout <= 0 {
-		return fmt.Errorf("timeout must be positive, got %d", cfg.Timeout)
+		errs.add(path+".timeout", "must be positive, got %d", cfg.Timeout)
+	}
+	
+	if !slices.Contains(Modes, cfg.Mode) {
+		errs.add(path+".mode", "unsupported mode: %s, supported modes: %s", cfg.Mode, strings.Join(Modes, ", "))
+	}
+	
+	if cfg.MinInterval < 0 {
+		errs.add(path+".min_interval", "must not be negative, got %d", cfg.MinInterval)
+	}
+	
+	if cfg.Retries < 0 {
+		errs.add(path+".retries", "must not be negative, got %d", cfg.Retries)
+	}
+	
+	if cfg.RetryBackoff <= 0 {
+		errs.add(path+".retry_backoff", "must be positive, got %d", cfg.RetryBackoff)
+	}
+	
+	if cfg.BreakerThreshold < 0 {
+		errs.add(path+".breaker_threshold", "must not be negative, got %d", cfg.BreakerThreshold)
+	}
+	
+	// It is only left 0 when the interval it defaults to is invalid, which
+	// is reported already
+	if cfg.BreakerProbeInterval < 0 {
+		errs.add(path+".breaker_probe_interval", "must be positive, got %d", cfg.BreakerProbeInterval)
+	}
+	
+	if cfg.Jitter < 0 {
+		errs.add(path+".jitter", "must not be negative, got %d", cfg.Jitter)
+	} else if cfg.Jitter > 0 && cfg.Schedule == "" && cfg.Jitter >= cfg.Interval {
+		errs.add(path+".jitter", "must be less than the interval (%ds), got %d", cfg.Interval, cfg.Jitter)
+	}
+	
+	if cfg.MaxAge < 0 {
+		errs.add(path+".max_age", "must not be negative, got %d", cfg.MaxAge)
+	} else if cfg.MaxAge > 0 && cfg.Mode == ModeInterval && cfg.Schedule == "" && cfg.MaxAge <= cfg.Interval {
+		errs.add(path+".max_age", "must be greater than the interval (%ds), or the output goes stale between runs, got %d", cfg.Interval, cfg.MaxAge)
 	}
 	
-	if cfg.ScriptPath == "" {
-		return fmt.Errorf("script_path cannot be empty")
+	if !slices.Contains(StaleActions, cfg.StaleAction) {
+		errs.add(path+".stale_action", "unsupported stale_action: %s, supported actions: %s", cfg.StaleAction, strings.Join(StaleActions, ", "))
 	}
 	
-	if cfg.ScriptType == "" {
-		return fmt.Errorf("script_type cannot be empty")
+	errs = append(errs, validateSchedule(path, cfg)...)
+	
+	if cfg.ScriptPath == "" && cfg.Inline == "" {
+		errs.add(path, "script_path or inline is required")
+	}
+	
+	if cfg.ScriptPath != "" && cfg.Inline != "" {
+		errs.add(path+".inline", "script_path and inline are mutually exclusive")
 	}
 	
 	// Validate script type
-	validTypes := map[string]bool{
-		"python":  true,
-		"python2": true,
-		"python3": true,
-		"shell":   true,
+	switch cfg.ScriptType {
+	case "":
+		errs.add(path+".script_type", "cannot be empty")
+	case ScriptTypeExec:
+	case ScriptTypeCustom:
+		if len(strings.Fields(cfg.Interpreter)) == 0 {
+			errs.add(path+".interpreter", "is required for script_type custom")
+		}
+	default:
+		if _, ok := interpreters[cfg.ScriptType]; !ok {
+			errs.add(path+".script_type", "unsupported script_type: %s, supported types: %s", cfg.ScriptType, strings.Join(scriptTypes(interpreters), ", "))
+		}
+	}
+	if cfg.Interpreter != "" && cfg.ScriptType != ScriptTypeCustom {
+		errs.add(path+".interpreter", "is only used with script_type custom")
+	}
+	
+	if !slices.Contains(LabelConflictModes, cfg.LabelConflict) {
+		errs.add(path+".label_conflict", "unsupported label_conflict: %s, supported modes: %s", cfg.LabelConflict, strings.Join(LabelConflictModes, ", "))
 	}
 	
-	if !validTypes[cfg.ScriptType] {
-		return fmt.Errorf("unsupported script_type: %s, supported types: python, python2, python3, shell", cfg.ScriptType)
+	if cfg.DefaultType != "" && !slices.Contains(DefaultTypes, cfg.DefaultType) {
+		errs.add(path+".default_type", "unsupported default_type: %s, supported types: %s", cfg.DefaultType, strings.Join(DefaultTypes, ", "))
 	}
 	
-	return nil
+	for varName := range cfg.Env {
+		if !identifierRE.MatchString(varName) {
+			errs.add(path+".env."+varName, "invalid environment variable name %q", varName)
+		} else if !envNameRE.MatchString(varName) {
+			errs.add(path+".env."+varName, "environment variable %s is reserved for the exporter", varName)
+		}
+	}
+	
+	for labelName := range cfg.Labels {
+		if !labelNameRE.MatchString(labelName) {
+			errs.add(path+".labels."+labelName, "invalid label name %q", labelName)
+		}
+	}
+	
+	return errs
+}
+
+// scriptTypes returns all valid script types, sorted.
+func scriptTypes(interpreters map[string]string) []string {
+	types := []string{ScriptTypeCustom, ScriptTypeExec}
+	for scriptType := range interpreters {
+		types = append(types, scriptType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// ScriptName describes a collector's script for messages.
+func (cfg CollectorConfig) ScriptName() string {
+	if cfg.Inline != "" {
+		return "<inline>"
+	}
+	return cfg.ScriptPath
+}
+
+// ScriptFile returns the absolute path of the file a collector runs, resolved
+// against its workdir and the exporter's working directory, or "" for an
+// inline script. The executor, the file watcher and check-config all use it,
+// so they agree on which file that is.
+func (cfg CollectorConfig) ScriptFile() string {
+	if cfg.Inline != "" {
+		return ""
+	}
+	path := cfg.ScriptPath
+	if !filepath.IsAbs(path) && cfg.Workdir != "" {
+		path = filepath.Join(cfg.Workdir, path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// InterpreterCommand returns the command line a collector's script is passed
+// to, or nil if the script is executed directly.
+func (c *Config) InterpreterCommand(cfg CollectorConfig) ([]string, error) {
+	switch cfg.ScriptType {
+	case ScriptTypeExec:
+		return nil, nil
+	case ScriptTypeCustom:
+		command := strings.Fields(cfg.Interpreter)
+		if len(command) == 0 {
+			return nil, fmt.Errorf("interpreter is required for script_type custom")
+		}
+		return command, nil
+	}
+	
+	command, ok := c.Global.Interpreters[cfg.ScriptType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported script type: %s", cfg.ScriptType)
+	}
+	return strings.Fields(command), nil
 }
 
 // SetupLogging configures the log output with rotation.
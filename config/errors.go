@@ -0,0 +1,117 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file defines the errors reported for an invalid configuration. Each
+// problem names the setting it concerns and, where known, the file, line and
+// column it was defined at.
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConfigError is one problem found in the configuration
+type ConfigError struct {
+	File    string
+	Line    int
+	Column  int
+	Path    string // setting concerned, e.g. clusters.prod.collectors.disk.interval
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		if e.Line > 0 {
+			fmt.Fprintf(&b, ":%d:%d", e.Line, e.Column)
+		}
+		b.WriteString(": ")
+	}
+	if e.Path != "" {
+		b.WriteString(e.Path + ": ")
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// ConfigErrors is the list of all problems found in the configuration
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// add records a problem with the setting at path
+func (e *ConfigErrors) add(path, format string, args ...interface{}) {
+	*e = append(*e, &ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// excluding returns the problems that don't concern a setting in reported or
+// one of its parts
+func (e ConfigErrors) excluding(reported ConfigErrors) ConfigErrors {
+	var remaining ConfigErrors
+	for _, err := range e {
+		covered := false
+		for _, r := range reported {
+			if err.Path == r.Path || strings.HasPrefix(err.Path, r.Path+".") || strings.HasPrefix(err.Path, r.Path+"[") {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			remaining = append(remaining, err)
+		}
+	}
+	return remaining
+}
+
+// position is where a setting is defined
+type position struct {
+	file   string
+	line   int
+	column int
+}
+
+// locate fills in the position of problems found after decoding, from the
+// setting itself or else the closest enclosing setting, and sorts them.
+func (e ConfigErrors) locate(positions map[string]position, defaultFile string) {
+	for _, err := range e {
+		if err.File != "" {
+			continue
+		}
+		err.File = defaultFile
+		for path := err.Path; path != ""; path = parentPath(path) {
+			if pos, ok := positions[path]; ok {
+				err.File, err.Line, err.Column = pos.file, pos.line, pos.column
+				break
+			}
+		}
+	}
+	sort.SliceStable(e, func(i, j int) bool {
+		if e[i].File != e[j].File {
+			return e[i].File < e[j].File
+		}
+		if e[i].Line != e[j].Line {
+			return e[i].Line < e[j].Line
+		}
+		return e[i].Column < e[j].Column
+	})
+}
+
+// parentPath strips the last key or index from a configuration path
+func parentPath(path string) string {
+	if i := strings.LastIndexAny(path, ".["); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file implements the expansion of ${VAR}, ${VAR:-default} and
+// ${file:/path} references in configuration values.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// expandConfig expands variable references in every string value of the
+// configuration, except fields tagged expand:"false". Undefined variables
+// without a default are an error in strict mode and empty otherwise.
+func expandConfig(cfg *Config) ConfigErrors {
+	var errs ConfigErrors
+	expandValue(reflect.ValueOf(cfg).Elem(), "", cfg.Global.StrictExpansion, &errs)
+	return errs
+}
+
+func expandValue(v reflect.Value, path string, strict bool, errs *ConfigErrors) {
+	switch v.Kind() {
+	case reflect.String:
+		expanded, err := expandString(v.String(), path, strict)
+		if err != nil {
+			errs.add(path, "%v", err)
+			return
+		}
+		v.SetString(expanded)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			expandValue(v.Elem(), path, strict, errs)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "-" || !field.IsExported() || field.Tag.Get("expand") == "false" {
+				continue
+			}
+			expandValue(v.Field(i), joinPath(path, name), strict, errs)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), strict, errs)
+		}
+	case reflect.Map:
+		// Map values aren't addressable: expand a copy and store it back
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			expandValue(elem, joinPath(path, key.String()), strict, errs)
+			v.SetMapIndex(key, elem)
+		}
+	}
+}
+
+// expandString expands the references in one value. "$${" stands for a
+// literal "${".
+func expandString(value, path string, strict bool) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			b.WriteString(value)
+			return b.String(), nil
+		}
+		if start > 0 && value[start-1] == '$' {
+			b.WriteString(value[:start-1] + "${")
+			value = value[start+2:]
+			continue
+		}
+		b.WriteString(value[:start])
+
+		end := strings.IndexByte(value[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", value[start:])
+		}
+		reference := value[start+2 : start+end]
+		value = value[start+end+1:]
+
+		expanded, err := resolveReference(reference, path, strict)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(expanded)
+	}
+}
+
+// resolveReference returns the value of a VAR, VAR:-default or file:/path
+// reference. Secret files usually end with a newline, which is dropped.
+func resolveReference(reference, path string, strict bool) (string, error) {
+	if file, ok := strings.CutPrefix(reference, "file:"); ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read ${file:%s}: %w", file, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	name, defaultValue, hasDefault := strings.Cut(reference, ":-")
+	if !identifierRE.MatchString(name) {
+		return "", fmt.Errorf("invalid variable name %q", name)
+	}
+	value, ok := os.LookupEnv(name)
+	switch {
+	case ok && (value != "" || !hasDefault):
+		return value, nil
+	case hasDefault:
+		return defaultValue, nil
+	case strict:
+		return "", fmt.Errorf("variable %s is not set", name)
+	}
+	logrus.Warnf("Configuration %s: variable %s is not set, using an empty value", path, name)
+	return "", nil
+}
+
+// joinPath appends a key to a dotted configuration path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandString(t *testing.T) {
+	t.Setenv("PE_TEST_HOST", "db.example.com")
+	t.Setenv("PE_TEST_EMPTY", "")
+	secret := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain", "plain"},
+		{"http://${PE_TEST_HOST}:8080/", "http://db.example.com:8080/"},
+		{"${PE_TEST_UNSET:-fallback}", "fallback"},
+		{"${PE_TEST_EMPTY:-fallback}", "fallback"},
+		{"${PE_TEST_EMPTY}", ""},
+		{"${PE_TEST_UNSET}", ""},
+		{"token=${file:" + secret + "}", "token=s3cret"},
+		{"$${PE_TEST_HOST} is ${PE_TEST_HOST}", "${PE_TEST_HOST} is db.example.com"},
+	}
+	for _, tt := range tests {
+		got, err := expandString(tt.value, "path", false)
+		if err != nil || got != tt.want {
+			t.Errorf("expandString(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpandStringErrors(t *testing.T) {
+	tests := []struct {
+		value  string
+		strict bool
+		want   string
+	}{
+		{"${PE_TEST_UNSET}", true, "variable PE_TEST_UNSET is not set"},
+		{"${PE_TEST_HOST", false, "unterminated reference"},
+		{"${1BAD}", false, "invalid variable name"},
+		{"${file:/nonexistent/secret}", false, "failed to read"},
+	}
+	for _, tt := range tests {
+		_, err := expandString(tt.value, "path", tt.strict)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("expandString(%q, strict %v) = %v, want an error containing %q", tt.value, tt.strict, err, tt.want)
+		}
+	}
+}
+
+func TestExpandConfig(t *testing.T) {
+	t.Setenv("PE_TEST_DIR", "/opt/scripts")
+	cfg := &Config{Clusters: map[string]ClusterConfig{
+		"prod": {
+			Env: map[string]string{"DIR": "${PE_TEST_DIR}"},
+			Collectors: map[string]CollectorConfig{
+				"disk": {
+					ScriptPath: "${PE_TEST_DIR}/disk.sh",
+					Args:       []string{"--dir", "${PE_TEST_DIR}"},
+					Inline:     "echo ${HOME}",
+				},
+				"broken": {ScriptPath: "${PE_TEST_DIR"},
+			},
+		},
+	}}
+
+	errs := expandConfig(cfg)
+	if len(errs) != 1 || errs[0].Path != "clusters.prod.collectors.broken.script_path" {
+		t.Errorf("errors = %v, want one for clusters.prod.collectors.broken.script_path", errs)
+	}
+	cluster := cfg.Clusters["prod"]
+	disk := cluster.Collectors["disk"]
+	if disk.ScriptPath != "/opt/scripts/disk.sh" || disk.Args[1] != "/opt/scripts" || cluster.Env["DIR"] != "/opt/scripts" {
+		t.Errorf("not expanded: %+v, env %v", disk, cluster.Env)
+	}
+	// Inline scripts are shell code and left alone
+	if disk.Inline != "echo ${HOME}" {
+		t.Errorf("inline = %q, want it unexpanded", disk.Inline)
+	}
+}
@@ -0,0 +1,233 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file implements configuration includes: the main configuration file
+// can pull in fragments matched by glob patterns, whose templates, clusters
+// and collectors are merged into it.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fragmentKeys are the top-level keys allowed in included files.
+var fragmentKeys = map[string]bool{
+	"clusters":  true,
+	"templates": true,
+}
+
+// IncludeFiles returns the files matched by the include patterns of the
+// configuration file at path, in load order: pattern by pattern, and sorted
+// by name within a pattern. Relative patterns are relative to the directory
+// of the configuration file.
+func IncludeFiles(path string, patterns []string) ([]string, error) {
+	seen := map[string]bool{filepath.Clean(path): true}
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(IncludePattern(path, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// IncludePattern resolves an include pattern against the configuration file
+func IncludePattern(path, pattern string) string {
+	if filepath.IsAbs(pattern) {
+		return filepath.Clean(pattern)
+	}
+	return filepath.Join(filepath.Dir(path), pattern)
+}
+
+// document is a configuration file merged with its includes
+type document struct {
+	root     *yaml.Node
+	files    []string
+	includes []string              // the include patterns, expanded
+	origins  map[*yaml.Node]string // file each mapping key comes from
+}
+
+// loadDocument reads the configuration file at path with all its includes
+// and merges them into one document.
+func loadDocument(path string) (*document, error) {
+	root, err := parseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := &document{
+		root:    root,
+		files:   []string{path},
+		origins: make(map[*yaml.Node]string),
+	}
+	recordOrigins(root, path, doc.origins)
+
+	var patterns []string
+	if include := mappingValue(root, "include"); include != nil {
+		if err := include.Decode(&patterns); err != nil {
+			return nil, fmt.Errorf("%s:%d: include must be a list of glob patterns", path, include.Line)
+		}
+		// The patterns are expanded before they are globbed, not with the
+		// other settings, since they decide which files are loaded
+		var errs ConfigErrors
+		strict := strictExpansion(root)
+		for i, pattern := range patterns {
+			expanded, err := expandString(pattern, fmt.Sprintf("include[%d]", i), strict)
+			if err != nil {
+				node := include.Content[i]
+				errs = append(errs, &ConfigError{File: path, Line: node.Line, Column: node.Column,
+					Path: fmt.Sprintf("include[%d]", i), Message: err.Error()})
+			}
+			patterns[i] = expanded
+		}
+		if len(errs) > 0 {
+			return nil, errs
+		}
+	}
+	doc.includes = patterns
+	includes, err := IncludeFiles(path, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs ConfigErrors
+	for _, file := range includes {
+		fragment, err := parseFile(file)
+		if err != nil {
+			return nil, err
+		}
+		doc.files = append(doc.files, file)
+		recordOrigins(fragment, file, doc.origins)
+		for i := 0; i+1 < len(fragment.Content); i += 2 {
+			key := fragment.Content[i]
+			if !fragmentKeys[key.Value] {
+				errs = append(errs, &ConfigError{File: file, Line: key.Line, Column: key.Column, Path: key.Value,
+					Message: "not allowed in an included file, only clusters and templates"})
+				fragment.Content[i+1] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+		}
+		mergeMapping(root, fragment, nil, doc.origins, &errs)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return doc, nil
+}
+
+// strictExpansion reads global.strict_expansion from the main file, for the
+// include patterns that are expanded before the configuration is decoded
+func strictExpansion(root *yaml.Node) bool {
+	var strict bool
+	if global := mappingValue(root, "global"); global != nil {
+		if value := mappingValue(global, "strict_expansion"); value != nil {
+			value.Decode(&strict)
+		}
+	}
+	return strict
+}
+
+// parseFile parses a YAML file whose top level must be a mapping
+func parseFile(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: the configuration must be a mapping", path, root.Line)
+	}
+	return root, nil
+}
+
+// recordOrigins remembers the file every mapping key comes from
+func recordOrigins(node *yaml.Node, file string, origins map[*yaml.Node]string) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			origins[node.Content[i]] = file
+		}
+	}
+	for _, child := range node.Content {
+		recordOrigins(child, file, origins)
+	}
+}
+
+// mergeMapping merges the keys of src into dst. The templates map, the
+// clusters map, each cluster and its collectors map are merged key by key;
+// any other key set in both is an error.
+func mergeMapping(dst, src *yaml.Node, path []string, origins map[*yaml.Node]string, errs *ConfigErrors) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		keyPath := append(path[:len(path):len(path)], key.Value)
+
+		j := mappingIndex(dst, key.Value)
+		if j < 0 {
+			dst.Content = append(dst.Content, key, value)
+			continue
+		}
+		existingKey, existing := dst.Content[j], dst.Content[j+1]
+		if mergeable(keyPath) && existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+			mergeMapping(existing, value, keyPath, origins, errs)
+			continue
+		}
+		*errs = append(*errs, &ConfigError{
+			File:    origins[key],
+			Line:    key.Line,
+			Column:  key.Column,
+			Path:    strings.Join(keyPath, "."),
+			Message: fmt.Sprintf("already defined at %s:%d", origins[existingKey], existingKey.Line),
+		})
+	}
+}
+
+// mergeable reports whether the mapping at path is merged across files
+func mergeable(path []string) bool {
+	switch {
+	case len(path) == 1:
+		return path[0] == "clusters" || path[0] == "templates"
+	case path[0] != "clusters":
+		return false
+	}
+	return len(path) == 2 || (len(path) == 3 && path[2] == "collectors")
+}
+
+// mappingIndex returns the index of key in a mapping node's content, or -1
+func mappingIndex(node *yaml.Node, key string) int {
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mappingValue returns the value of key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if i := mappingIndex(node, key); i >= 0 {
+		return node.Content[i+1]
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseNode parses text as the content of file
+func parseNode(t *testing.T, text, file string, origins map[*yaml.Node]string) *yaml.Node {
+	t.Helper()
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		t.Fatal(err)
+	}
+	recordOrigins(doc.Content[0], file, origins)
+	return doc.Content[0]
+}
+
+func TestMergeMapping(t *testing.T) {
+	origins := make(map[*yaml.Node]string)
+	root := parseNode(t, strings.Join([]string{
+		"clusters:",
+		"  prod:",
+		"    collectors:",
+		"      disk: {interval: 60}",
+		"templates:",
+		"  base: {timeout: 10}",
+	}, "\n"), "main.yml", origins)
+	fragment := parseNode(t, strings.Join([]string{
+		"clusters:",
+		"  prod:",
+		"    collectors:",
+		"      memory: {interval: 30}",
+		"  staging:",
+		"    collectors: {}",
+		"templates:",
+		"  slow: {timeout: 60}",
+	}, "\n"), "extra.yml", origins)
+
+	var errs ConfigErrors
+	mergeMapping(root, fragment, nil, origins, &errs)
+	if len(errs) > 0 {
+		t.Fatalf("mergeMapping() errors = %v", errs)
+	}
+
+	var merged struct {
+		Clusters  map[string]map[string]map[string]interface{}
+		Templates map[string]interface{}
+	}
+	if err := root.Decode(&merged); err != nil {
+		t.Fatal(err)
+	}
+	collectors := merged.Clusters["prod"]["collectors"]
+	if len(collectors) != 2 || collectors["disk"] == nil || collectors["memory"] == nil {
+		t.Errorf("prod collectors = %v, want disk and memory", collectors)
+	}
+	if _, ok := merged.Clusters["staging"]; !ok {
+		t.Errorf("clusters = %v, want staging added", merged.Clusters)
+	}
+	if len(merged.Templates) != 2 {
+		t.Errorf("templates = %v, want base and slow", merged.Templates)
+	}
+}
+
+func TestMergeMappingDuplicates(t *testing.T) {
+	tests := []struct {
+		name     string
+		fragment string
+		path     string
+	}{
+		{"collector", "clusters:\n  prod:\n    collectors:\n      disk: {interval: 30}", "clusters.prod.collectors.disk"},
+		{"template", "templates:\n  base: {timeout: 5}", "templates.base"},
+		{"cluster setting", "clusters:\n  prod:\n    workdir: /tmp", "clusters.prod.workdir"},
+		{"top-level setting", "global:\n  port: 9000", "global"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origins := make(map[*yaml.Node]string)
+			root := parseNode(t, strings.Join([]string{
+				"global:",
+				"  port: 8080",
+				"clusters:",
+				"  prod:",
+				"    workdir: /opt",
+				"    collectors:",
+				"      disk: {interval: 60}",
+				"templates:",
+				"  base: {timeout: 10}",
+			}, "\n"), "main.yml", origins)
+			fragment := parseNode(t, tt.fragment, "extra.yml", origins)
+
+			var errs ConfigErrors
+			mergeMapping(root, fragment, nil, origins, &errs)
+			if len(errs) != 1 {
+				t.Fatalf("mergeMapping() errors = %v, want one", errs)
+			}
+			err := errs[0]
+			if err.Path != tt.path || err.File != "extra.yml" || !strings.HasPrefix(err.Message, "already defined at main.yml:") {
+				t.Errorf("error = %+v, want %s already defined in main.yml", err, tt.path)
+			}
+		})
+	}
+}
+
+func writeTestFile(t *testing.T, path, text string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigExpandsIncludePatterns(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(confDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PE_TEST_CONF_DIR", confDir)
+	fragment := filepath.Join(confDir, "db.yaml")
+	writeTestFile(t, fragment, strings.Join([]string{
+		"clusters:",
+		"  db:",
+		"    enabled: true",
+		"    collectors:",
+		"      mysql: {enabled: true, script_path: mysql.sh, script_type: shell}",
+	}, "\n"))
+	path := filepath.Join(dir, "config.yaml")
+	writeTestFile(t, path, strings.Join([]string{
+		"include: ['${PE_TEST_CONF_DIR}/*.yaml']",
+		"global:",
+		"  log_file: /tmp/exporter.log",
+	}, "\n"))
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() = %v", err)
+	}
+	if _, ok := cfg.Clusters["db"]; !ok {
+		t.Error("the included cluster was not loaded")
+	}
+	// The watcher finds the included files from cfg.Include, like the loader
+	if want := []string{confDir + "/*.yaml"}; !reflect.DeepEqual(cfg.Include, want) {
+		t.Errorf("Include = %q, want %q", cfg.Include, want)
+	}
+	if files, _ := IncludeFiles(path, cfg.Include); !reflect.DeepEqual(files, []string{fragment}) {
+		t.Errorf("IncludeFiles() = %q, want %q", files, fragment)
+	}
+}
+
+func TestLoadConfigReportsIncludeExpansionErrors(t *testing.T) {
+	path, errs := loadErrors(t, strings.Join([]string{
+		"global:",
+		"  log_file: /tmp/exporter.log",
+		"  strict_expansion: true",
+		"include:",
+		"  - conf.d/*.yaml",
+		"  - ${PE_TEST_UNSET}/*.yaml",
+	}, "\n"))
+
+	want := ConfigError{File: path, Line: 6, Column: 5, Path: "include[1]", Message: "variable PE_TEST_UNSET is not set"}
+	if len(errs) != 1 || *errs[0] != want {
+		t.Errorf("errors = %v, want %q", errs, &want)
+	}
+}
@@ -0,0 +1,156 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file implements the schedule settings of collectors: cron expressions,
+// the time zone they are evaluated in, and the windows of time in which a
+// collector is allowed to run.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronParser accepts cron expressions with five or, with seconds, six fields
+// and descriptors such as @hourly or @every 90s.
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Weekdays are the day names of active windows, in any case.
+var Weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// timeOfDayRE matches the times of day of active windows, HH:MM. 24:00 is
+// the end of the day.
+var timeOfDayRE = regexp.MustCompile(`^(([01]?[0-9]|2[0-3]):[0-5][0-9]|24:00)$`)
+
+// TimeWindow is a time of day, on some days of the week, during which a
+// collector may run. A window whose end is before its start ends on the next
+// day.
+type TimeWindow struct {
+	Days  []string `yaml:"days"`  // weekdays the window starts on, all if empty
+	Start string   `yaml:"start"` // HH:MM, the start of the day if empty
+	End   string   `yaml:"end"`   // HH:MM, the end of the day if empty
+}
+
+// Location returns the time zone of a collector's schedule and windows
+func (cfg CollectorConfig) Location() (*time.Location, error) {
+	if cfg.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(cfg.Timezone)
+}
+
+// CronSchedule parses a collector's schedule, in its time zone. It returns
+// nil if the collector has no schedule.
+func (cfg CollectorConfig) CronSchedule() (cron.Schedule, error) {
+	if cfg.Schedule == "" {
+		return nil, nil
+	}
+	spec := cfg.Schedule
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		if cfg.Timezone != "" {
+			return nil, fmt.Errorf("the time zone is set by both timezone and the schedule")
+		}
+	} else if cfg.Timezone != "" {
+		spec = "CRON_TZ=" + cfg.Timezone + " " + spec
+	}
+	return cronParser.Parse(spec)
+}
+
+// Contains reports whether t, in its own location, falls into the window
+func (w TimeWindow) Contains(t time.Time) bool {
+	days, start, end, err := w.bounds()
+	if err != nil {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	today := days&(1<<uint(t.Weekday())) != 0
+	if start < end {
+		return today && minute >= start && minute < end
+	}
+	// The window crosses midnight: it is either in its first or second day
+	yesterday := days&(1<<uint((t.Weekday()+6)%7)) != 0
+	return (today && minute >= start) || (yesterday && minute < end)
+}
+
+// NextStart returns the first start of the window after t, in t's location
+func (w TimeWindow) NextStart(t time.Time) time.Time {
+	days, start, _, err := w.bounds()
+	if err != nil || days == 0 {
+		return time.Time{}
+	}
+	for i := 0; i <= 7; i++ {
+		candidate := time.Date(t.Year(), t.Month(), t.Day()+i, start/60, start%60, 0, 0, t.Location())
+		if candidate.After(t) && days&(1<<uint(candidate.Weekday())) != 0 {
+			return candidate
+		}
+	}
+	return time.Time{}
+}
+
+// bounds returns the weekdays of a window as a bit mask and its start and end
+// as minutes of the day.
+func (w TimeWindow) bounds() (days uint8, start, end int, err error) {
+	if len(w.Days) == 0 {
+		days = 1<<7 - 1
+	}
+	for _, name := range w.Days {
+		i := slices.Index(Weekdays, strings.ToLower(name))
+		if i < 0 {
+			return 0, 0, 0, fmt.Errorf("invalid day %q, use %s", name, strings.Join(Weekdays, ", "))
+		}
+		days |= 1 << uint(i)
+	}
+	if start, err = parseTimeOfDay(w.Start, 0); err != nil || start == 24*60 {
+		return 0, 0, 0, fmt.Errorf("invalid start: %q is not a time of day (HH:MM)", w.Start)
+	}
+	if end, err = parseTimeOfDay(w.End, 24*60); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid end: %q is not a time of day (HH:MM)", w.End)
+	}
+	if start == end {
+		return 0, 0, 0, fmt.Errorf("start and end must differ")
+	}
+	return days, start, end, nil
+}
+
+// parseTimeOfDay parses HH:MM into minutes of the day; 24:00 is the end of
+// the day.
+func parseTimeOfDay(value string, empty int) (int, error) {
+	if value == "" {
+		return empty, nil
+	}
+	if !timeOfDayRE.MatchString(value) {
+		return 0, fmt.Errorf("not a time of day")
+	}
+	hours, minutes, _ := strings.Cut(value, ":")
+	h, _ := strconv.Atoi(hours)
+	m, _ := strconv.Atoi(minutes)
+	return h*60 + m, nil
+}
+
+// validateSchedule checks the schedule settings of a collector
+func validateSchedule(path string, cfg CollectorConfig) ConfigErrors {
+	var errs ConfigErrors
+	if _, err := cfg.Location(); err != nil {
+		errs.add(path+".timezone", "%v", err)
+	} else if _, err := cfg.CronSchedule(); err != nil {
+		errs.add(path+".schedule", "invalid schedule %q: %v", cfg.Schedule, err)
+	}
+	if cfg.Schedule != "" && cfg.Mode == ModeOnScrape {
+		errs.add(path+".schedule", "cannot be used with mode on_scrape")
+	}
+	for i, window := range cfg.ActiveWindows {
+		if _, _, _, err := window.bounds(); err != nil {
+			errs.add(fmt.Sprintf("%s.active_windows[%d]", path, i), "%v", err)
+		}
+	}
+	return errs
+}
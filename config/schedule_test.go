@@ -0,0 +1,101 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns a time in April 2025; the 4th is a Friday
+func at(day, hour, minute int) time.Time {
+	return time.Date(2025, time.April, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	overnight := TimeWindow{Days: []string{"fri"}, Start: "22:00", End: "02:00"}
+	office := TimeWindow{Days: []string{"Mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:30"}
+	evening := TimeWindow{Start: "18:00"}
+
+	tests := []struct {
+		name   string
+		window TimeWindow
+		t      time.Time
+		want   bool
+	}{
+		{"overnight before start", overnight, at(4, 21, 59), false},
+		{"overnight at start", overnight, at(4, 22, 0), true},
+		{"overnight before midnight", overnight, at(4, 23, 59), true},
+		{"overnight after midnight", overnight, at(5, 1, 59), true},
+		{"overnight at end", overnight, at(5, 2, 0), false},
+		{"overnight on the next evening", overnight, at(5, 23, 0), false},
+		{"overnight early on its start day", overnight, at(4, 1, 0), false},
+		{"office hours", office, at(4, 12, 0), true},
+		{"office at end", office, at(4, 17, 30), false},
+		{"office on saturday", office, at(5, 12, 0), false},
+		{"until the end of the day", evening, at(6, 23, 59), true},
+		{"before an open start", evening, at(6, 17, 59), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Contains(tt.t); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeWindowNextStart(t *testing.T) {
+	overnight := TimeWindow{Days: []string{"fri"}, Start: "22:00", End: "02:00"}
+	daily := TimeWindow{Start: "09:00", End: "17:00"}
+
+	tests := []struct {
+		name   string
+		window TimeWindow
+		t      time.Time
+		want   time.Time
+	}{
+		{"later the same day", overnight, at(4, 12, 0), at(4, 22, 0)},
+		{"inside the window, past midnight", overnight, at(5, 1, 0), at(11, 22, 0)},
+		{"exactly at the start", overnight, at(4, 22, 0), at(11, 22, 0)},
+		{"after the end of a daily window", daily, at(6, 17, 30), at(7, 9, 0)},
+		{"before a daily window", daily, at(6, 8, 0), at(6, 9, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.NextStart(tt.t); !got.Equal(tt.want) {
+				t.Errorf("NextStart(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeWindowNextStartInLocation(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	window := TimeWindow{Start: "08:00", End: "09:00"}
+	// 23:00 UTC is 07:00 the next day in Shanghai
+	got := window.NextStart(at(4, 23, 0).In(shanghai))
+	if want := time.Date(2025, time.April, 5, 8, 0, 0, 0, shanghai); !got.Equal(want) {
+		t.Errorf("NextStart() = %v, want %v", got, want)
+	}
+}
+
+func TestTimeWindowBounds(t *testing.T) {
+	tests := []struct {
+		window TimeWindow
+		valid  bool
+	}{
+		{TimeWindow{Start: "00:00", End: "24:00"}, true},
+		{TimeWindow{Start: "24:00"}, false},
+		{TimeWindow{Start: "9:00", End: "9:30"}, true},
+		{TimeWindow{Start: "09:60"}, false},
+		{TimeWindow{Start: "10:00", End: "10:00"}, false},
+		{TimeWindow{Days: []string{"monday"}}, false},
+	}
+	for _, tt := range tests {
+		if _, _, _, err := tt.window.bounds(); (err == nil) != tt.valid {
+			t.Errorf("bounds(%+v) error = %v, want valid %v", tt.window, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file generates a JSON Schema for the configuration file from the
+// Config structure, so editors and linters can check a configuration with
+// the same settings, defaults and constraints the exporter uses.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SchemaVersion is the JSON Schema dialect of the generated schema
+const SchemaVersion = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema, limited to the keywords the configuration needs
+type Schema struct {
+	Version              string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"` // false or a *Schema
+	PropertyNames        *Schema            `json:"propertyNames,omitempty"`
+	Not                  *Schema            `json:"not,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Examples             []string           `json:"examples,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Maximum              *int               `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// settingRule holds what the schema says about one setting beyond its type.
+// Settings are keyed by struct name and YAML key. The allowed values of a
+// list or a map apply to its items or values.
+type settingRule struct {
+	description  string
+	minimum      *int
+	maximum      *int
+	minLength    *int
+	enum         []string
+	examples     []string
+	pattern      string
+	keyPattern   string   // of the keys of a map
+	reservedKeys []string // keys a map must not have
+	dflt         interface{}
+	template     bool // the setting holds collector settings for inheritance
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// settingRules describes the settings and the constraints validate checks
+func settingRules() map[string]settingRule {
+	// Settings are set to their default when 0 or empty
+	orDefault := intPtr(0)
+	orEmpty := func(values []string) []string {
+		return append(slices.Clip(values), "")
+	}
+	global := DefaultConfig().Global
+	logLevels := make([]string, len(logrus.AllLevels))
+	for i, level := range logrus.AllLevels {
+		logLevels[i] = level.String()
+	}
+	// Level names are "warning" and so on, logrus also parses "warn"
+	logLevels = append(logLevels, "warn")
+	levels := "^(" + caseInsensitive(logLevels) + ")?$"
+	knownTypes := scriptTypes(builtinInterpreters)
+	timeOfDay := `([01]?[0-9]|2[0-3]):[0-5][0-9]`
+
+	return map[string]settingRule{
+		"Config.include":   {description: "Glob patterns of files with more clusters and templates, relative to this file"},
+		"Config.global":    {description: "Settings of the exporter itself"},
+		"Config.templates": {description: "Named collector settings that collectors and cluster defaults can extend", template: true},
+		"Config.clusters":  {description: "Clusters by name"},
+
+		"GlobalConfig.log_file":                {description: "Path of the log file", minLength: intPtr(1)},
+		"GlobalConfig.log_level":               {description: "Log level of the exporter", examples: logLevels, pattern: levels, dflt: global.LogLevel},
+		"GlobalConfig.log_max_age":             {description: "Days to keep rotated log files", minimum: orDefault, dflt: global.LogMaxAge},
+		"GlobalConfig.log_rotation_time":       {description: "Hours between log rotations", minimum: orDefault, dflt: global.LogRotationTime},
+		"GlobalConfig.default_scrape_interval": {description: "Collector interval in seconds when not set", minimum: orDefault, dflt: global.DefaultScrapeInterval},
+		"GlobalConfig.default_timeout":         {description: "Collector timeout in seconds when not set", minimum: orDefault, dflt: global.DefaultTimeout},
+		"GlobalConfig.http_port":               {description: "Port of the metrics and API server", minimum: orDefault, maximum: intPtr(65535), dflt: global.HTTPPort},
+		"GlobalConfig.http_timeout":            {description: "Read and write timeout of the HTTP server in seconds", minimum: orDefault, dflt: global.HTTPTimeout},
+		"GlobalConfig.max_concurrent_scripts":  {description: "Scripts run at the same time across all clusters", minimum: orDefault, dflt: global.MaxConcurrentScripts},
+		"GlobalConfig.kill_grace_period":       {description: "Seconds between SIGTERM and SIGKILL for a script that timed out", minimum: orDefault, dflt: global.KillGracePeriod},
+		"GlobalConfig.stderr_log_level":        {description: "Level script stderr is logged at", examples: logLevels, pattern: levels, dflt: global.StderrLogLevel},
+		"GlobalConfig.max_stderr_bytes":        {description: "Bytes of script stderr kept per run", minimum: orDefault, dflt: global.MaxStderrBytes},
+		"GlobalConfig.interpreters":            {description: "Interpreter command lines by script_type, in addition to the built-in ones", pattern: `\S`, reservedKeys: []string{ScriptTypeExec, ScriptTypeCustom}},
+		"GlobalConfig.watch_config":            {description: "Reload when the configuration or an included file changes"},
+		"GlobalConfig.watch_scripts":           {description: "Re-run collectors when their script changes"},
+		"GlobalConfig.watch_debounce_ms":       {description: "Milliseconds without changes before reacting to them", minimum: orDefault, dflt: global.WatchDebounceMs},
+		"GlobalConfig.strict_expansion":        {description: "Fail on references to undefined environment variables"},
+		"GlobalConfig.splay":                   {description: "Offset the runs of interval collectors by a hash of host, cluster and collector name, spreading them across the interval"},
+		"GlobalConfig.startup_spread":          {description: "Seconds the first runs of the collectors are spread over at startup", minimum: intPtr(0)},
+
+		"ClusterConfig.enabled":                {description: "Run the collectors of this cluster"},
+		"ClusterConfig.max_concurrent_scripts": {description: "Scripts of this cluster run at the same time, 0 for only the global limit", minimum: intPtr(0)},
+		"ClusterConfig.inject_labels":          {description: "Add cluster and collector labels to every series"},
+		"ClusterConfig.label_conflict":         {description: "What to do when a script sets an injected label", enum: orEmpty(LabelConflictModes), dflt: LabelConflictExported},
+		"ClusterConfig.labels":                 {description: "Static labels for all collectors of the cluster", keyPattern: labelNameRE.String()},
+		"ClusterConfig.args":                   {description: "Default script arguments"},
+		"ClusterConfig.env":                    {description: "Environment for all collectors of the cluster", keyPattern: envNameRE.String()},
+		"ClusterConfig.env_from_file":          {description: "Default KEY=VALUE file read before each execution"},
+		"ClusterConfig.workdir":                {description: "Default working directory"},
+		"ClusterConfig.inherit_env":            {description: "Pass the exporter's environment to scripts", dflt: true},
+		"ClusterConfig.defaults":               {description: "Settings inherited by all collectors of the cluster", template: true},
+		"ClusterConfig.collectors":             {description: "Collectors by name"},
+
+		"CollectorConfig.enabled":                {description: "Run this collector"},
+		"CollectorConfig.extends":                {description: "Template to inherit settings from"},
+		"CollectorConfig.mode":                   {description: "interval runs the script in the background, on_scrape when /metrics is scraped", enum: orEmpty(Modes), dflt: ModeInterval},
+		"CollectorConfig.interval":               {description: "Seconds between runs, defaults to global.default_scrape_interval", minimum: orDefault},
+		"CollectorConfig.min_interval":           {description: "Seconds an on_scrape result is reused for by later scrapes", minimum: intPtr(0)},
+		"CollectorConfig.schedule":               {description: "Cron expression with optional seconds field, or a descriptor like @hourly, instead of interval", examples: []string{"*/5 * * * *", "0 30 2 * * *", "@every 90s"}},
+		"CollectorConfig.timezone":               {description: "Time zone of schedule and active_windows, defaults to the local time zone", examples: []string{"UTC", "Asia/Shanghai"}},
+		"CollectorConfig.jitter":                 {description: "Random delay of up to this many seconds added to every scheduled run, less than interval", minimum: intPtr(0)},
+		"CollectorConfig.retries":                {description: "Retries of a failed run, as long as they start before the next scheduled run", minimum: intPtr(0)},
+		"CollectorConfig.retry_backoff":          {description: "Seconds before the first retry, doubled for every further one", minimum: orDefault, dflt: 1},
+		"CollectorConfig.breaker_threshold":      {description: "Consecutive failed runs that open the circuit breaker, 0 to never open it", minimum: intPtr(0)},
+		"CollectorConfig.breaker_probe_interval": {description: "Seconds between probe runs while the circuit breaker is open, defaults to 5 times interval", minimum: orDefault},
+		"CollectorConfig.active_windows":         {description: "Times of day the collector may run in, any time if empty"},
+		"CollectorConfig.max_age":                {description: "Seconds after which the collector's output is stale, 0 for never; more than interval", minimum: intPtr(0)},
+		"CollectorConfig.stale_action":           {description: "What happens to stale output: drop it, label its series stale=\"true\", or drop it and set collector_output_stale", enum: orEmpty(StaleActions), dflt: StaleDrop},
+
+		"TimeWindow.days":                {description: "Weekdays the window starts on, every day if empty", examples: Weekdays, pattern: "^(" + caseInsensitive(Weekdays) + ")$"},
+		"TimeWindow.start":               {description: "Start time of day, HH:MM", pattern: "^(" + timeOfDay + ")?$"},
+		"TimeWindow.end":                 {description: "End time of day, HH:MM or 24:00; before start for windows crossing midnight", pattern: "^(" + timeOfDay + "|24:00)?$"},
+		"CollectorConfig.timeout":        {description: "Seconds a run may take, defaults to global.default_timeout", minimum: orDefault},
+		"CollectorConfig.script_path":    {description: "Script to run, relative to workdir"},
+		"CollectorConfig.inline":         {description: "Script body, instead of script_path"},
+		"CollectorConfig.script_type":    {description: "Interpreter of the script: a key of global.interpreters, exec or custom", examples: knownTypes},
+		"CollectorConfig.interpreter":    {description: "Interpreter command line for script_type custom"},
+		"CollectorConfig.args":           {description: "Script arguments, defaults to the cluster args"},
+		"CollectorConfig.env":            {description: "Environment merged over the cluster env", keyPattern: envNameRE.String()},
+		"CollectorConfig.env_from_file":  {description: "KEY=VALUE file read before each execution"},
+		"CollectorConfig.workdir":        {description: "Working directory, defaults to the cluster's"},
+		"CollectorConfig.inherit_env":    {description: "Pass the exporter's environment to the script, defaults to the cluster setting"},
+		"CollectorConfig.inject_labels":  {description: "Add cluster and collector labels, defaults to the cluster setting"},
+		"CollectorConfig.label_conflict": {description: "What to do when the script sets an injected label, defaults to the cluster setting", enum: orEmpty(LabelConflictModes)},
+		"CollectorConfig.labels":         {description: "Static labels merged over the cluster labels", keyPattern: labelNameRE.String()},
+		"CollectorConfig.default_type":   {description: "Type of metric families the script does not declare", enum: orEmpty(DefaultTypes)},
+		"CollectorConfig.default_help":   {description: "Help of metric families the script does not describe"},
+	}
+}
+
+// schemaRequired lists the settings that have no default, by struct name
+var schemaRequired = map[string][]string{
+	"GlobalConfig": {"log_file"},
+}
+
+// JSONSchema returns the JSON Schema of the configuration file
+func JSONSchema() *Schema {
+	g := &schemaGenerator{
+		rules: settingRules(),
+		defs:  make(map[string]*Schema),
+	}
+	root := g.structSchema(reflect.TypeOf(Config{}))
+	root.Version = SchemaVersion
+	root.Title = "public_exporter configuration"
+	root.Defs = g.defs
+	return root
+}
+
+type schemaGenerator struct {
+	rules map[string]settingRule
+	defs  map[string]*Schema
+}
+
+// typeSchema returns the schema of values of type t. Structs other than
+// Config are defined once under $defs and referenced.
+func (g *schemaGenerator) typeSchema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // placeholder, for recursive types
+			g.defs[t.Name()] = g.structSchema(t)
+		}
+		return &Schema{Ref: "#/$defs/" + t.Name()}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.typeSchema(t.Elem())}
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: g.typeSchema(t.Elem())}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer"}
+	}
+	return &Schema{Type: "string"}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: false,
+		Required:             schemaRequired[t.Name()],
+	}
+	fields := yamlFields(t)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		rule, ok := g.rules[t.Name()+"."+name]
+		var property *Schema
+		if rule.template {
+			property = g.templateSchema(fields[name].Type)
+		} else {
+			property = g.typeSchema(fields[name].Type)
+		}
+		if ok {
+			apply(property, rule)
+		}
+		s.Properties[name] = property
+	}
+	return s
+}
+
+// templateSchema returns the schema of collector settings in templates and
+// cluster defaults, which lack the settings collectors don't inherit. It is
+// defined once under $defs as <struct name>Template.
+func (g *schemaGenerator) templateSchema(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Map {
+		return &Schema{Type: "object", AdditionalProperties: g.templateSchema(t.Elem())}
+	}
+	name := t.Name() + "Template"
+	if _, ok := g.defs[name]; !ok {
+		s := g.structSchema(t)
+		for key, field := range yamlFields(t) {
+			if !allowedInTemplates(field) {
+				delete(s.Properties, key)
+			}
+		}
+		g.defs[name] = s
+	}
+	return &Schema{Ref: "#/$defs/" + name}
+}
+
+// apply adds a setting's rule to the schema of its value. Keywords next to
+// $ref are allowed since draft 2019-09, so settings of a struct type keep
+// their own description. The allowed values of a list apply to its items,
+// those of a map to its values.
+func apply(s *Schema, rule settingRule) {
+	s.Description = rule.description
+	s.Minimum = rule.minimum
+	s.Maximum = rule.maximum
+	s.Default = rule.dflt
+
+	values := s
+	if s.Type == "array" {
+		values = s.Items
+	} else if valueSchema, ok := s.AdditionalProperties.(*Schema); ok {
+		values = valueSchema
+	}
+	values.Enum = rule.enum
+	values.Examples = rule.examples
+	values.Pattern = rule.pattern
+	values.MinLength = rule.minLength
+
+	if rule.keyPattern != "" || len(rule.reservedKeys) > 0 {
+		s.PropertyNames = &Schema{Pattern: rule.keyPattern}
+		if len(rule.reservedKeys) > 0 {
+			s.PropertyNames.Not = &Schema{Enum: rule.reservedKeys}
+		}
+	}
+}
+
+// caseInsensitive returns an alternation of values in any case, for settings
+// the loader parses case-insensitively. JSON Schema patterns have no flags,
+// so every letter becomes a character class.
+func caseInsensitive(values []string) string {
+	alternatives := make([]string, len(values))
+	for i, value := range values {
+		var b strings.Builder
+		for _, r := range value {
+			if upper, lower := unicode.ToUpper(r), unicode.ToLower(r); upper != lower {
+				fmt.Fprintf(&b, "[%c%c]", upper, lower)
+			} else {
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		alternatives[i] = b.String()
+	}
+	return strings.Join(alternatives, "|")
+}
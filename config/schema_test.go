@@ -0,0 +1,273 @@
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// configStructs returns the structs reachable from Config by name
+func configStructs(t reflect.Type, structs map[string]reflect.Type) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		configStructs(t.Elem(), structs)
+	case reflect.Struct:
+		if _, ok := structs[t.Name()]; ok {
+			return
+		}
+		structs[t.Name()] = t
+		for _, field := range yamlFields(t) {
+			configStructs(field.Type, structs)
+		}
+	}
+}
+
+func TestSettingRulesCoverSettings(t *testing.T) {
+	structs := make(map[string]reflect.Type)
+	configStructs(reflect.TypeOf(Config{}), structs)
+	rules := settingRules()
+
+	settings := make(map[string]bool)
+	for name, typ := range structs {
+		for key := range yamlFields(typ) {
+			settings[name+"."+key] = true
+			if _, ok := rules[name+"."+key]; !ok {
+				t.Errorf("no schema rule for %s.%s", name, key)
+			}
+		}
+	}
+	for key := range rules {
+		if !settings[key] {
+			t.Errorf("schema rule for unknown setting %s", key)
+		}
+	}
+}
+
+// accepts reports whether a string passes the string keywords of s
+func accepts(s *Schema, value string) bool {
+	if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(value) {
+		return false
+	}
+	if s.Enum != nil && !slices.Contains(s.Enum, value) {
+		return false
+	}
+	if s.MinLength != nil && len(value) < *s.MinLength {
+		return false
+	}
+	return s.Not == nil || !accepts(s.Not, value)
+}
+
+// validationErrors returns the problems of a minimal configuration changed by
+// set
+func validationErrors(set func(cfg *Config)) ConfigErrors {
+	cfg := &Config{
+		Global: GlobalConfig{LogFile: "/tmp/exporter.log"},
+		Clusters: map[string]ClusterConfig{"prod": {
+			Enabled: true,
+			Collectors: map[string]CollectorConfig{"disk": {
+				Enabled:    true,
+				ScriptPath: "disk.sh",
+				ScriptType: "shell",
+			}},
+		}},
+	}
+	set(cfg)
+	errs := cfg.setDefaults()
+	return append(errs, cfg.validate()...)
+}
+
+// setCollector changes the collector of a validationErrors configuration
+func setCollector(set func(cfg *CollectorConfig)) func(cfg *Config) {
+	return func(c *Config) {
+		cfg := c.Clusters["prod"].Collectors["disk"]
+		set(&cfg)
+		c.Clusters["prod"].Collectors["disk"] = cfg
+	}
+}
+
+func TestSchemaMatchesValidation(t *testing.T) {
+	defs := JSONSchema().Defs
+	property := func(def, key string) *Schema {
+		return defs[def].Properties[key]
+	}
+	collector := "clusters.prod.collectors.disk"
+
+	tests := []struct {
+		setting string
+		schema  *Schema // of the strings checked
+		values  []string
+		set     func(cfg *Config, value string)
+		path    string
+	}{
+		{
+			"log_level", property("GlobalConfig", "log_level"),
+			[]string{"debug", "INFO", "warn", "Warning", "error", "verbose", "", "info "},
+			func(cfg *Config, value string) { cfg.Global.LogLevel = value },
+			"global.log_level",
+		},
+		{
+			"stderr_log_level", property("GlobalConfig", "stderr_log_level"),
+			[]string{"trace", "WARN", "fatal", "notice"},
+			func(cfg *Config, value string) { cfg.Global.StderrLogLevel = value },
+			"global.stderr_log_level",
+		},
+		{
+			"mode", property("CollectorConfig", "mode"),
+			[]string{"", "interval", "on_scrape", "Interval", "cron"},
+			func(cfg *Config, value string) {
+				setCollector(func(c *CollectorConfig) { c.Mode = value })(cfg)
+			},
+			collector + ".mode",
+		},
+		{
+			"stale_action", property("CollectorConfig", "stale_action"),
+			[]string{"", "drop", "label", "metric", "keep"},
+			func(cfg *Config, value string) {
+				setCollector(func(c *CollectorConfig) { c.StaleAction = value })(cfg)
+			},
+			collector + ".stale_action",
+		},
+		{
+			"label_conflict", property("ClusterConfig", "label_conflict"),
+			[]string{"", "exported", "overwrite", "keep", "rename"},
+			func(cfg *Config, value string) {
+				cluster := cfg.Clusters["prod"]
+				cluster.LabelConflict = value
+				cfg.Clusters["prod"] = cluster
+			},
+			collector + ".label_conflict",
+		},
+		{
+			"default_type", property("CollectorConfig", "default_type"),
+			[]string{"", "gauge", "counter", "untyped", "histogram"},
+			func(cfg *Config, value string) {
+				setCollector(func(c *CollectorConfig) { c.DefaultType = value })(cfg)
+			},
+			collector + ".default_type",
+		},
+		{
+			"interpreters keys", property("GlobalConfig", "interpreters").PropertyNames,
+			[]string{"ruby", "exec", "custom", "executable"},
+			func(cfg *Config, value string) { cfg.Global.Interpreters = map[string]string{value: "ruby"} },
+			"global.interpreters",
+		},
+		{
+			"interpreters values", property("GlobalConfig", "interpreters").AdditionalProperties.(*Schema),
+			[]string{"ruby -w", "", "  ", " ruby"},
+			func(cfg *Config, value string) { cfg.Global.Interpreters = map[string]string{"ruby": value} },
+			"global.interpreters",
+		},
+		{
+			"label names", property("CollectorConfig", "labels").PropertyNames,
+			[]string{"team", "_", "_team", "__name__", "__", "1team", "team-name", "Team_2"},
+			func(cfg *Config, value string) {
+				setCollector(func(c *CollectorConfig) { c.Labels = map[string]string{value: "x"} })(cfg)
+			},
+			collector + ".labels",
+		},
+		{
+			"cluster label names", property("ClusterConfig", "labels").PropertyNames,
+			[]string{"dc", "__dc"},
+			func(cfg *Config, value string) {
+				cluster := cfg.Clusters["prod"]
+				cluster.Labels = map[string]string{value: "x"}
+				cfg.Clusters["prod"] = cluster
+			},
+			collector + ".labels",
+		},
+		{
+			"env names", property("CollectorConfig", "env").PropertyNames,
+			[]string{"HOME", "P", "PE", "PEX", "PE1", "P_E", "PE_CLUSTER", "PE_", "pe_x", "_PE_X", "1X", "A-B"},
+			func(cfg *Config, value string) {
+				setCollector(func(c *CollectorConfig) { c.Env = map[string]string{value: "x"} })(cfg)
+			},
+			collector + ".env",
+		},
+		{
+			"days", property("TimeWindow", "days").Items,
+			[]string{"mon", "MON", "Sat", "monday", "", "xyz"},
+			func(cfg *Config, value string) {
+				setCollector(func(c *CollectorConfig) { c.ActiveWindows = []TimeWindow{{Days: []string{value}}} })(cfg)
+			},
+			collector + ".active_windows",
+		},
+		{
+			"start", property("TimeWindow", "start"),
+			[]string{"", "0:00", "07:30", "23:59", "24:00", "7:5", "+7:00", "07:+5", "007:00", "12:60", "25:00"},
+			func(cfg *Config, value string) {
+				setCollector(func(c *CollectorConfig) { c.ActiveWindows = []TimeWindow{{Start: value}} })(cfg)
+			},
+			collector + ".active_windows",
+		},
+		{
+			"end", property("TimeWindow", "end"),
+			[]string{"", "07:30", "24:00", "24:01", "-0:00", "7:00am"},
+			func(cfg *Config, value string) {
+				setCollector(func(c *CollectorConfig) { c.ActiveWindows = []TimeWindow{{Start: "12:00", End: value}} })(cfg)
+			},
+			collector + ".active_windows",
+		},
+	}
+	for _, tt := range tests {
+		for _, value := range tt.values {
+			valid := true
+			for _, err := range validationErrors(func(cfg *Config) { tt.set(cfg, value) }) {
+				if strings.HasPrefix(err.Path, tt.path) {
+					valid = false
+				}
+			}
+			if schemaValid := accepts(tt.schema, value); schemaValid != valid {
+				t.Errorf("%s %q: schema accepts it: %v, validation: %v", tt.setting, value, schemaValid, valid)
+			}
+		}
+	}
+}
+
+func TestSchemaMatchesValidationOfNumbers(t *testing.T) {
+	s := JSONSchema()
+	// Zero selects the default, so the loader accepts it for every setting
+	for _, value := range []int{-1, 0} {
+		errs := validationErrors(func(cfg *Config) {
+			cfg.Global.HTTPPort = value
+			cfg.Global.LogMaxAge = value
+			setCollector(func(c *CollectorConfig) {
+				c.Interval = value
+				c.RetryBackoff = value
+				c.Jitter = value
+			})(cfg)
+		})
+		for path, schema := range map[string]*Schema{
+			"global.http_port":                            s.Defs["GlobalConfig"].Properties["http_port"],
+			"global.log_max_age":                          s.Defs["GlobalConfig"].Properties["log_max_age"],
+			"clusters.prod.collectors.disk.interval":      s.Defs["CollectorConfig"].Properties["interval"],
+			"clusters.prod.collectors.disk.retry_backoff": s.Defs["CollectorConfig"].Properties["retry_backoff"],
+			"clusters.prod.collectors.disk.jitter":        s.Defs["CollectorConfig"].Properties["jitter"],
+		} {
+			valid := !slices.ContainsFunc(errs, func(err *ConfigError) bool { return err.Path == path })
+			if schemaValid := schema.Minimum == nil || value >= *schema.Minimum; schemaValid != valid {
+				t.Errorf("%s %d: schema accepts it: %v, validation: %v", path, value, schemaValid, valid)
+			}
+		}
+	}
+}
+
+func TestTemplateSchemaMatchesInheritance(t *testing.T) {
+	s := JSONSchema()
+	if ref := s.Properties["templates"].AdditionalProperties.(*Schema).Ref; ref != "#/$defs/CollectorConfigTemplate" {
+		t.Errorf("templates refer to %s, want the template definition", ref)
+	}
+	if ref := s.Defs["ClusterConfig"].Properties["defaults"].Ref; ref != "#/$defs/CollectorConfigTemplate" {
+		t.Errorf("cluster defaults refer to %s, want the template definition", ref)
+	}
+
+	template := s.Defs["CollectorConfigTemplate"]
+	for key := range yamlFields(reflect.TypeOf(CollectorConfig{})) {
+		cfg := CollectorConfig{present: map[string]bool{key: true}}
+		allowed := len(checkInheritable("templates.base", cfg)) == 0
+		if _, inSchema := template.Properties[key]; inSchema != allowed {
+			t.Errorf("%s in the template schema: %v, allowed in templates: %v", key, inSchema, allowed)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file implements the strict check of a configuration document against
+// the Config structure: unknown keys, duplicate keys and values of the wrong
+// type are reported with their file, line and column before decoding.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxSuggestionDistance is the largest edit distance at which an unknown
+// key is assumed to be a typo of a known one.
+const maxSuggestionDistance = 2
+
+// checker walks a configuration document alongside the Go types it decodes
+// into, recording the position of every setting.
+type checker struct {
+	origins   map[*yaml.Node]string
+	positions map[string]position
+	errs      ConfigErrors
+}
+
+// check verifies the merged document and returns the positions of all
+// settings, for locating later problems.
+func (doc *document) check() (map[string]position, ConfigErrors) {
+	ch := &checker{
+		origins:   doc.origins,
+		positions: make(map[string]position),
+	}
+	ch.checkNode(doc.root, reflect.TypeOf(Config{}), "", doc.files[0])
+	return ch.positions, ch.errs
+}
+
+func (ch *checker) checkNode(node *yaml.Node, t reflect.Type, path, file string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			ch.typeError(node, t, path, file)
+			return
+		}
+		fields := yamlFields(t)
+		ch.checkMapping(node, path, file, func(key, value *yaml.Node, keyPath, keyFile string) {
+			field, ok := fields[key.Value]
+			if !ok {
+				ch.unknownField(key, keyPath, keyFile, fields)
+				return
+			}
+			ch.checkNode(value, field.Type, keyPath, keyFile)
+		})
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			ch.typeError(node, t, path, file)
+			return
+		}
+		ch.checkMapping(node, path, file, func(key, value *yaml.Node, keyPath, keyFile string) {
+			ch.checkNode(value, t.Elem(), keyPath, keyFile)
+		})
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			ch.typeError(node, t, path, file)
+			return
+		}
+		for i, item := range node.Content {
+			ch.checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), file)
+		}
+	default:
+		if node.Kind != yaml.ScalarNode || node.Decode(reflect.New(t).Interface()) != nil {
+			ch.typeError(node, t, path, file)
+		}
+	}
+}
+
+// checkMapping calls each for every key of a mapping, after recording its
+// position and rejecting duplicates. Duplicates are removed once reported,
+// so that the rest of the document still decodes with the first definition.
+// Keys merged in with "<<" are checked like the mapping's own keys.
+func (ch *checker) checkMapping(node *yaml.Node, path, file string, each func(key, value *yaml.Node, keyPath, keyFile string)) {
+	seen := make(map[string]*yaml.Node)
+	kept := node.Content[:0]
+	defer func() { node.Content = kept }()
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		keyFile := file
+		if origin, ok := ch.origins[key]; ok {
+			keyFile = origin
+		}
+
+		if key.Tag == "!!merge" {
+			if value.Kind == yaml.AliasNode {
+				value = value.Alias
+			}
+			merged := []*yaml.Node{value}
+			if value.Kind == yaml.SequenceNode {
+				merged = value.Content
+			}
+			for _, m := range merged {
+				if m.Kind == yaml.AliasNode {
+					m = m.Alias
+				}
+				if m.Kind == yaml.MappingNode {
+					ch.checkMapping(m, path, keyFile, each)
+				}
+			}
+			kept = append(kept, key, node.Content[i+1])
+			continue
+		}
+
+		keyPath := joinPath(path, key.Value)
+		if previous, ok := seen[key.Value]; ok {
+			ch.add(key, keyPath, keyFile, "already defined at line %d", previous.Line)
+			continue
+		}
+		kept = append(kept, key, value)
+		seen[key.Value] = key
+		ch.positions[keyPath] = position{file: keyFile, line: key.Line, column: key.Column}
+		each(key, value, keyPath, keyFile)
+	}
+}
+
+func (ch *checker) add(node *yaml.Node, path, file, format string, args ...interface{}) {
+	ch.errs = append(ch.errs, &ConfigError{
+		File:    file,
+		Line:    node.Line,
+		Column:  node.Column,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (ch *checker) unknownField(key *yaml.Node, path, file string, fields map[string]reflect.StructField) {
+	best, bestDistance := "", maxSuggestionDistance+1
+	for name := range fields {
+		if d := editDistance(key.Value, name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	if best != "" {
+		ch.add(key, path, file, "unknown field, did you mean %s?", best)
+		return
+	}
+	ch.add(key, path, file, "unknown field")
+}
+
+func (ch *checker) typeError(node *yaml.Node, t reflect.Type, path, file string) {
+	got := fmt.Sprintf("%q", node.Value)
+	switch node.Kind {
+	case yaml.MappingNode:
+		got = "a mapping"
+	case yaml.SequenceNode:
+		got = "a list"
+	}
+	ch.add(node, path, file, "expected %s, got %s", describeType(t), got)
+}
+
+// describeType names the YAML value expected for a Go type
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "a mapping"
+	case reflect.Slice:
+		return "a list"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int64:
+		return "an integer"
+	}
+	return "a string"
+}
+
+// yamlFields returns the fields of a struct by their YAML key
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = field
+		}
+	}
+	return fields
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadErrors writes a configuration file and returns the problems LoadConfig
+// reports for it
+func loadErrors(t *testing.T, text string) (string, ConfigErrors) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadConfig(path)
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("LoadConfig() = %v, want ConfigErrors", err)
+	}
+	return path, errs
+}
+
+func TestLoadConfigReportsPositions(t *testing.T) {
+	path, errs := loadErrors(t, strings.Join([]string{
+		"global:",
+		"  log_file: /tmp/exporter.log",
+		"  http_prot: 8080",
+		"clusters:",
+		"  prod:",
+		"    enabled: true",
+		"    collectors:",
+		"      disk:",
+		"        enabled: true",
+		"        interval: soon",
+		"        timeout: -1",
+		"        script_path: disk.sh",
+		"        script_type: shell",
+		"        script_type: python",
+	}, "\n"))
+
+	want := []ConfigError{
+		{File: path, Line: 3, Column: 3, Path: "global.http_prot", Message: "unknown field, did you mean http_port?"},
+		{File: path, Line: 10, Column: 19, Path: "clusters.prod.collectors.disk.interval", Message: "expected an integer, got \"soon\""},
+		{File: path, Line: 11, Column: 9, Path: "clusters.prod.collectors.disk.timeout", Message: "must be positive, got -1"},
+		{File: path, Line: 14, Column: 9, Path: "clusters.prod.collectors.disk.script_type", Message: "already defined at line 13"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(errs), len(want), errs)
+	}
+	for i, err := range errs {
+		if *err != want[i] {
+			t.Errorf("error %d = %q, want %q", i, err, &want[i])
+		}
+	}
+}
+
+func TestLoadConfigLocatesMissingSettings(t *testing.T) {
+	path, errs := loadErrors(t, strings.Join([]string{
+		"global:",
+		"  log_file: /tmp/exporter.log",
+		"clusters:",
+		"  prod:",
+		"    enabled: true",
+		"    collectors:",
+		"      disk:",
+		"        enabled: true",
+		"        script_type: shell",
+	}, "\n"))
+
+	// A missing setting is reported at the collector that lacks it
+	if len(errs) != 1 || errs[0].File != path || errs[0].Line != 7 || errs[0].Path != "clusters.prod.collectors.disk" {
+		t.Errorf("errors = %v, want one at line 7 for the disk collector", errs)
+	}
+}
+
+func TestLoadConfigNegativeIntervalReportedOnce(t *testing.T) {
+	_, errs := loadErrors(t, strings.Join([]string{
+		"global:",
+		"  log_file: /tmp/exporter.log",
+		"clusters:",
+		"  prod:",
+		"    enabled: true",
+		"    collectors:",
+		"      disk:",
+		"        enabled: true",
+		"        interval: -1",
+		"        script_path: disk.sh",
+		"        script_type: shell",
+	}, "\n"))
+
+	// The breaker probe interval is derived from the interval, and not
+	// reported as well
+	if len(errs) != 1 || errs[0].Path != "clusters.prod.collectors.disk.interval" {
+		t.Errorf("errors = %v, want one for the interval", errs)
+	}
+}
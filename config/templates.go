@@ -0,0 +1,193 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file implements collector templates and cluster defaults: collector
+// settings declared once and inherited by many collectors.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// resolveTemplates returns cfg with the settings of the template it extends,
+// and of the templates that one extends, filled in.
+func (c *Config) resolveTemplates(cfg CollectorConfig) (CollectorConfig, error) {
+	var chain []string
+	for name := cfg.Extends; name != ""; {
+		for _, seen := range chain {
+			if seen == name {
+				return cfg, fmt.Errorf("template cycle: %s -> %s", strings.Join(chain, " -> "), name)
+			}
+		}
+		chain = append(chain, name)
+
+		template, ok := c.Templates[name]
+		if !ok {
+			return cfg, fmt.Errorf("unknown template %q", name)
+		}
+		inheritSettings(&cfg, template)
+		name = template.Extends
+	}
+	return cfg, nil
+}
+
+// UnmarshalYAML decodes a collector and records which of its settings are
+// present, so that a setting explicitly set to zero, such as retries: 0, is
+// not inherited over. A setting with a null value counts as absent. Wrongly
+// typed settings are left out rather than failing the whole collector, which
+// yaml.v3 would drop; the strict check reports them.
+func (cfg *CollectorConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain CollectorConfig
+	if err := node.Decode((*plain)(cfg)); err != nil {
+		if _, ok := err.(*yaml.TypeError); !ok {
+			return err
+		}
+	}
+	cfg.present = make(map[string]bool)
+	mappingKeys(node, cfg.present)
+	return nil
+}
+
+// mappingKeys adds the keys of a mapping with a non-null value to keys,
+// including those merged in with "<<"
+func mappingKeys(node *yaml.Node, keys map[string]bool) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Tag == "!!merge" {
+			if value.Kind == yaml.AliasNode {
+				value = value.Alias
+			}
+			if value.Kind == yaml.SequenceNode {
+				for _, merged := range value.Content {
+					mappingKeys(merged, keys)
+				}
+			} else {
+				mappingKeys(value, keys)
+			}
+			continue
+		}
+		if value.Tag != "!!null" {
+			keys[key.Value] = true
+		}
+	}
+}
+
+// isSet reports whether a setting of cfg is present in the configuration or,
+// for collectors not loaded from a file, has a non-zero value
+func (cfg *CollectorConfig) isSet(key string, value reflect.Value) bool {
+	return cfg.present[key] || !value.IsZero()
+}
+
+// inheritSettings fills the settings cfg leaves unset from base. Maps such as
+// env and labels are merged, with the entries of cfg winning. Fields tagged
+// inherit:"false" are never inherited.
+func inheritSettings(cfg *CollectorConfig, base CollectorConfig) {
+	// The script and its interpreter are inherited as a whole
+	skip := make(map[string]bool)
+	if cfg.ScriptPath != "" || cfg.Inline != "" {
+		skip["script_path"], skip["inline"] = true, true
+	}
+	if cfg.ScriptType != "" {
+		skip["interpreter"] = true
+	}
+
+	present := make(map[string]bool, len(cfg.present))
+	for key := range cfg.present {
+		present[key] = true
+	}
+	dst, src := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(base)
+	for i := 0; i < dst.NumField(); i++ {
+		fieldType := dst.Type().Field(i)
+		key := strings.Split(fieldType.Tag.Get("yaml"), ",")[0]
+		if !fieldType.IsExported() || fieldType.Tag.Get("inherit") == "false" || skip[key] {
+			continue
+		}
+		field := dst.Field(i)
+		switch {
+		case field.Kind() == reflect.Map:
+			merged := mergeMaps(src.Field(i).Interface().(map[string]string), field.Interface().(map[string]string))
+			field.Set(reflect.ValueOf(merged))
+		case !cfg.isSet(key, field) && base.isSet(key, src.Field(i)):
+			// Inherited settings count as set for the next template
+			field.Set(src.Field(i))
+			present[key] = true
+		}
+	}
+	cfg.present = present
+}
+
+// checkInheritable reports settings of a template or of cluster defaults that
+// collectors never inherit
+func checkInheritable(path string, cfg CollectorConfig) ConfigErrors {
+	var errs ConfigErrors
+	t := reflect.TypeOf(cfg)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if !allowedInTemplates(field) && cfg.present[key] {
+			errs.add(path+"."+key, "is not inherited by collectors, set it on each collector")
+		}
+	}
+	return errs
+}
+
+// allowedInTemplates reports whether a collector setting may be set in a
+// template or in cluster defaults: settings collectors don't inherit may
+// not, except for extends.
+func allowedInTemplates(field reflect.StructField) bool {
+	return field.Tag.Get("inherit") != "false" || strings.Split(field.Tag.Get("yaml"), ",")[0] == "extends"
+}
+
+// clusterSettings are the collector settings that can also be set on a
+// cluster, as a shorthand for setting them in its defaults
+var clusterSettings = []string{"inject_labels", "label_conflict", "labels", "args", "env", "env_from_file", "workdir", "inherit_env"}
+
+// applyClusterSettings returns defaults with the collector settings set on
+// the cluster filled in. A setting may be given in only one of the two places,
+// as otherwise it is unclear which one applies.
+func applyClusterSettings(path string, cluster ClusterConfig, defaults CollectorConfig) (CollectorConfig, ConfigErrors) {
+	var errs ConfigErrors
+	present := make(map[string]bool, len(defaults.present))
+	for key := range defaults.present {
+		present[key] = true
+	}
+	src, dst := reflect.ValueOf(cluster), reflect.ValueOf(&defaults).Elem()
+	for _, key := range clusterSettings {
+		value := src.FieldByIndex(fieldByKey(src.Type(), key).Index)
+		if value.IsZero() {
+			continue
+		}
+		field := dst.FieldByIndex(fieldByKey(dst.Type(), key).Index)
+		if defaults.isSet(key, field) {
+			errs.add(path+"."+key, "is also set in defaults, set it in only one place")
+			continue
+		}
+		field.Set(value)
+		present[key] = true
+	}
+	defaults.present = present
+	return defaults, errs
+}
+
+// fieldByKey returns the field of a struct with the given yaml key
+func fieldByKey(t reflect.Type, key string) reflect.StructField {
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0] == key {
+			return t.Field(i)
+		}
+	}
+	panic("no field for " + key)
+}
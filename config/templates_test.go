@@ -0,0 +1,229 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeCollector decodes a collector the way LoadConfig does
+func decodeCollector(t *testing.T, text string) CollectorConfig {
+	t.Helper()
+	var cfg CollectorConfig
+	if err := yaml.Unmarshal([]byte(text), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestInheritSettings(t *testing.T) {
+	base := decodeCollector(t, strings.Join([]string{
+		"retries: 3",
+		"timeout: 30",
+		"jitter: 5",
+		"script_path: check.sh",
+		"env: {A: base, B: base}",
+		"labels: {team: ops}",
+	}, "\n"))
+
+	tests := []struct {
+		name  string
+		yaml  string
+		check func(cfg CollectorConfig) bool
+	}{
+		{"unset settings are inherited", "interval: 60", func(cfg CollectorConfig) bool {
+			return cfg.Retries == 3 && cfg.Timeout == 30 && cfg.ScriptPath == "check.sh"
+		}},
+		{"zero values override", "retries: 0\njitter: 0", func(cfg CollectorConfig) bool {
+			return cfg.Retries == 0 && cfg.Jitter == 0 && cfg.Timeout == 30
+		}},
+		{"null values inherit", "retries: ~", func(cfg CollectorConfig) bool {
+			return cfg.Retries == 3
+		}},
+		{"maps are merged", "env: {A: own, C: own}", func(cfg CollectorConfig) bool {
+			return reflect.DeepEqual(cfg.Env, map[string]string{"A": "own", "B": "base", "C": "own"}) &&
+				reflect.DeepEqual(cfg.Labels, map[string]string{"team": "ops"})
+		}},
+		{"the script is inherited as a whole", "inline: echo up 1", func(cfg CollectorConfig) bool {
+			return cfg.Inline == "echo up 1" && cfg.ScriptPath == ""
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := decodeCollector(t, tt.yaml)
+			inheritSettings(&cfg, base)
+			if !tt.check(cfg) {
+				t.Errorf("inheritSettings() = %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestInheritSettingsKeepsBase(t *testing.T) {
+	base := decodeCollector(t, "retries: 3\nenv: {A: base}")
+	cfg := decodeCollector(t, "timeout: 5\nenv: {A: own}")
+	inheritSettings(&cfg, base)
+	if base.present["timeout"] || base.Env["A"] != "base" {
+		t.Errorf("base = %+v, want it unchanged", base)
+	}
+}
+
+func TestResolveTemplatesChain(t *testing.T) {
+	c := &Config{Templates: map[string]CollectorConfig{
+		"base":  decodeCollector(t, "retries: 3\ntimeout: 30"),
+		"quick": decodeCollector(t, "extends: base\nretries: 0\ntimeout: 5"),
+	}}
+
+	// quick sets retries to 0, which base must not fill in again
+	cfg, err := c.resolveTemplates(decodeCollector(t, "extends: quick\ntimeout: 10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Retries != 0 || cfg.Timeout != 10 {
+		t.Errorf("retries, timeout = %d, %d, want 0, 10", cfg.Retries, cfg.Timeout)
+	}
+
+	c.Templates["base"] = decodeCollector(t, "extends: quick")
+	if _, err := c.resolveTemplates(decodeCollector(t, "extends: quick")); err == nil || !strings.Contains(err.Error(), "template cycle") {
+		t.Errorf("resolveTemplates() error = %v, want a template cycle", err)
+	}
+}
+
+func TestCheckInheritable(t *testing.T) {
+	errs := checkInheritable("templates.base", decodeCollector(t, "extends: other\nenabled: false\nretries: 1"))
+	if len(errs) != 1 || errs[0].Path != "templates.base.enabled" {
+		t.Errorf("checkInheritable() = %v, want an error for enabled only", errs)
+	}
+}
+
+// loadCluster loads a configuration with the given cluster and templates and
+// returns the collector "disk" of the cluster
+func loadCluster(t *testing.T, cluster, templates []string) CollectorConfig {
+	t.Helper()
+	lines := []string{"global:", "  log_file: /tmp/exporter.log", "templates:"}
+	for _, line := range templates {
+		lines = append(lines, "  "+line)
+	}
+	lines = append(lines, "clusters:", "  prod:", "    enabled: true")
+	for _, line := range cluster {
+		lines = append(lines, "    "+line)
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestFile(t, path, strings.Join(lines, "\n"))
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() = %v", err)
+	}
+	return cfg.Clusters["prod"].Collectors["disk"]
+}
+
+func TestClusterSettingsPrecedence(t *testing.T) {
+	templates := []string{"slow: {timeout: 60, workdir: /template}"}
+	tests := []struct {
+		name    string
+		cluster []string
+		check   func(cfg CollectorConfig) bool
+	}{
+		{"cluster settings apply", []string{
+			"workdir: /cluster",
+			"inherit_env: false",
+			"inject_labels: true",
+			"collectors:",
+			"  disk: {enabled: true, script_path: disk.sh, script_type: shell}",
+		}, func(cfg CollectorConfig) bool {
+			return cfg.Workdir == "/cluster" && !*cfg.InheritEnv && *cfg.InjectLabels
+		}},
+		{"cluster and defaults settings combine", []string{
+			"workdir: /cluster",
+			"defaults: {timeout: 5}",
+			"collectors:",
+			"  disk: {enabled: true, script_path: disk.sh, script_type: shell}",
+		}, func(cfg CollectorConfig) bool {
+			return cfg.Workdir == "/cluster" && cfg.Timeout == 5
+		}},
+		{"collectors override the cluster", []string{
+			"workdir: /cluster",
+			"args: [a]",
+			"collectors:",
+			"  disk: {enabled: true, script_path: disk.sh, script_type: shell, workdir: /own, args: []}",
+		}, func(cfg CollectorConfig) bool {
+			return cfg.Workdir == "/own" && cfg.Args != nil && len(cfg.Args) == 0
+		}},
+		{"templates override the cluster", []string{
+			"workdir: /cluster",
+			"collectors:",
+			"  disk: {enabled: true, extends: slow, script_path: disk.sh, script_type: shell}",
+		}, func(cfg CollectorConfig) bool {
+			return cfg.Workdir == "/template" && cfg.Timeout == 60
+		}},
+		{"env and labels are merged", []string{
+			"env: {A: cluster, B: cluster}",
+			"labels: {dc: east}",
+			"collectors:",
+			"  disk: {enabled: true, script_path: disk.sh, script_type: shell, env: {B: own}, labels: {team: ops}}",
+		}, func(cfg CollectorConfig) bool {
+			return reflect.DeepEqual(cfg.Env, map[string]string{"A": "cluster", "B": "own"}) &&
+				reflect.DeepEqual(cfg.Labels, map[string]string{"dc": "east", "team": "ops"})
+		}},
+		{"defaults without cluster settings", []string{
+			"collectors:",
+			"  disk: {enabled: true, script_path: disk.sh, script_type: shell}",
+		}, func(cfg CollectorConfig) bool {
+			return *cfg.InheritEnv && !*cfg.InjectLabels && cfg.LabelConflict == LabelConflictExported
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cfg := loadCluster(t, tt.cluster, templates); !tt.check(cfg) {
+				t.Errorf("collector = %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestClusterSettingsConflictWithDefaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		wantPath string
+		wantLine int
+	}{
+		{"workdir", []string{
+			"    workdir: /cluster",
+			"    defaults: {workdir: /defaults}",
+		}, "clusters.prod.workdir", 8},
+		{"inherited by defaults from a template", []string{
+			"    env: {A: cluster}",
+			"    defaults: {extends: base}",
+		}, "clusters.prod.env", 8},
+		{"false on the cluster", []string{
+			"    inject_labels: false",
+			"    defaults: {inject_labels: true}",
+		}, "clusters.prod.inject_labels", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := []string{
+				"global:",
+				"  log_file: /tmp/exporter.log",
+				"templates:",
+				"  base: {env: {A: template}}",
+				"clusters:",
+				"  prod:",
+				"    enabled: true",
+			}
+			lines = append(lines, tt.lines...)
+			lines = append(lines,
+				"    collectors:",
+				"      disk: {enabled: true, script_path: disk.sh, script_type: shell}",
+			)
+			_, errs := loadErrors(t, strings.Join(lines, "\n"))
+			if len(errs) != 1 || errs[0].Path != tt.wantPath || errs[0].Line != tt.wantLine {
+				t.Errorf("LoadConfig() errors = %v, want one for %s at line %d", errs, tt.wantPath, tt.wantLine)
+			}
+		})
+	}
+}
@@ -3,9 +3,12 @@ module public_exporter
 go 1.21
 
 require (
-	github.com/sirupsen/logrus v1.9.3
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible
-	gopkg.in/yaml.v2 v2.4.0
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/sync v0.7.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -2,8 +2,10 @@
 # -*- coding: utf-8 -*-
 
 from __future__ import print_function
+import os
 import subprocess
 import socket
+import sys
 
 # Mapping of hostname to list of processes to monitor
 HOST_PROCESS_MAP = {
@@ -21,8 +23,15 @@ HOST_PROCESS_MAP = {
 
 def get_process_list():
     """
-    Return process list based on current hostname.
+    Return the processes to check: the script arguments if given (collector
+    `args`), else the comma-separated CHECK_PROCESSES variable (collector
+    `env`), else the list for the current hostname.
     """
+    if len(sys.argv) > 1:
+        return sys.argv[1:]
+    from_env = os.environ.get("CHECK_PROCESSES", "")
+    if from_env.strip():
+        return [name.strip() for name in from_env.split(",") if name.strip()]
     hostname = socket.gethostname().lower()
     return HOST_PROCESS_MAP.get(hostname, HOST_PROCESS_MAP["default"])
 
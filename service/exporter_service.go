@@ -10,22 +10,37 @@
 package service
 
 import (
+	"fmt"
+	"github.com/sirupsen/logrus"
 	"log"
 	"public_exporter/config"
 	"public_exporter/collector"
+	"sync"
+	"time"
 )
 
 // ExporterService is the service layer that coordinates the CollectorManager.
 type ExporterService struct {
 	Config           *config.Config
+	ConfigPath       string
 	CollectorManager *collector.CollectorManager
+
+	reloadMu             sync.Mutex   // serializes reloads
+	watcher              *FileWatcher // nil unless watch_config or watch_scripts is set
+	stopped              bool
+	mu                   sync.Mutex   // guards the fields above and below, never held during a reload
+	lastReloadSuccessful bool
+	lastReloadTime       time.Time // time of the last successful (re)load
 }
 
 // NewExporterService creates a new ExporterService.
-func NewExporterService(cfg *config.Config, cm *collector.CollectorManager) *ExporterService {
+func NewExporterService(cfg *config.Config, configPath string, cm *collector.CollectorManager) *ExporterService {
 	return &ExporterService{
-		Config:           cfg,
-		CollectorManager: cm,
+		Config:               cfg,
+		ConfigPath:           configPath,
+		CollectorManager:     cm,
+		lastReloadSuccessful: true,
+		lastReloadTime:       time.Now(),
 	}
 }
 
@@ -37,14 +52,119 @@ func (es *ExporterService) Start() error {
 	if err := es.CollectorManager.Start(); err != nil {
 		return err
 	}
+
+	es.mu.Lock()
+	es.updateWatcher()
+	es.mu.Unlock()
 	
 	log.Println("Exporter service started successfully.")
 	return nil
 }
 
+// Reload re-reads the configuration file and applies it to the running
+// collectors. If the new configuration is invalid the current one stays in
+// effect. Once the service is stopping, reloads are refused.
+//
+// Stopping removed collectors may take up to the kill grace period, so es.mu
+// is only held to read and swap the state; CurrentConfig and ReloadStatus
+// don't wait for a reload.
+func (es *ExporterService) Reload() error {
+	es.reloadMu.Lock()
+	defer es.reloadMu.Unlock()
+
+	es.mu.Lock()
+	stopped, oldCfg := es.stopped, es.Config
+	es.mu.Unlock()
+	if stopped {
+		log.Println("Not reloading the configuration, the exporter is shutting down")
+		return fmt.Errorf("the exporter is shutting down")
+	}
+
+	log.Printf("Reloading configuration from %s...", es.ConfigPath)
+	newCfg, err := config.LoadConfig(es.ConfigPath)
+	if err != nil {
+		es.mu.Lock()
+		es.lastReloadSuccessful = false
+		es.mu.Unlock()
+		log.Printf("Configuration reload failed: %v", err)
+		return fmt.Errorf("failed to reload configuration: %w", err)
+	}
+
+	// Settings of the HTTP server and log files are only read at startup
+	oldGlobal, newGlobal := oldCfg.Global, newCfg.Global
+	if newGlobal.LogLevel != oldGlobal.LogLevel {
+		if level, err := logrus.ParseLevel(newGlobal.LogLevel); err == nil {
+			logrus.SetLevel(level)
+		}
+	}
+	if newGlobal.HTTPPort != oldGlobal.HTTPPort || newGlobal.HTTPTimeout != oldGlobal.HTTPTimeout ||
+		newGlobal.LogFile != oldGlobal.LogFile || newGlobal.LogMaxAge != oldGlobal.LogMaxAge ||
+		newGlobal.LogRotationTime != oldGlobal.LogRotationTime {
+		log.Println("HTTP server and log file settings changed; they take effect after a restart")
+	}
+
+	es.CollectorManager.Reload(newCfg)
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.Config = newCfg
+	es.updateWatcher()
+	es.lastReloadSuccessful = true
+	es.lastReloadTime = time.Now()
+	log.Println("Configuration reloaded successfully.")
+	return nil
+}
+
+// updateWatcher starts the file watcher if the configuration asks for it, or
+// updates the files it watches. The caller must hold es.mu.
+func (es *ExporterService) updateWatcher() {
+	if es.stopped {
+		return
+	}
+	if es.watcher != nil {
+		es.watcher.refresh(es.Config)
+		return
+	}
+	if !es.Config.Global.WatchConfig && !es.Config.Global.WatchScripts {
+		return
+	}
+	watcher, err := NewFileWatcher(es)
+	if err != nil {
+		log.Printf("Failed to start file watcher: %v", err)
+		return
+	}
+	watcher.Start(es.Config)
+	es.watcher = watcher
+	log.Println("Watching configuration and scripts for changes.")
+}
+
+// CurrentConfig returns the configuration in effect
+func (es *ExporterService) CurrentConfig() *config.Config {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.Config
+}
+
+// ReloadStatus reports whether the last reload succeeded and when the
+// configuration was last loaded successfully.
+func (es *ExporterService) ReloadStatus() (bool, time.Time) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.lastReloadSuccessful, es.lastReloadTime
+}
+
 // Stop gracefully stops the exporter service.
 func (es *ExporterService) Stop() {
 	log.Println("Stopping exporter service...")
+	// The watcher may be waiting for es.mu to reload, so stop it unlocked
+	es.mu.Lock()
+	watcher := es.watcher
+	es.watcher = nil
+	es.stopped = true
+	es.mu.Unlock()
+	if watcher != nil {
+		watcher.Stop()
+	}
 	es.CollectorManager.Stop()
 	log.Println("Exporter service stopped.")
 }
@@ -0,0 +1,105 @@
+//go:build !windows
+
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"public_exporter/collector"
+	"public_exporter/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a configuration running the given inline scripts once
+// an hour
+func writeConfig(t *testing.T, path string, scripts map[string]string) {
+	t.Helper()
+	lines := []string{
+		"global:",
+		"  log_file: " + filepath.Join(filepath.Dir(path), "exporter.log"),
+		"  kill_grace_period: 2",
+		"clusters:",
+		"  test:",
+		"    enabled: true",
+		"    collectors:",
+	}
+	for name, script := range scripts {
+		lines = append(lines,
+			"      "+name+":",
+			"        enabled: true",
+			"        interval: 3600",
+			"        timeout: 60",
+			"        script_type: custom",
+			"        interpreter: sh",
+			"        inline: "+script,
+		)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitForFile waits until a script has created a file
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+	}
+	t.Fatalf("%s was not created", path)
+}
+
+func TestReloadStatusDuringSlowReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	started := filepath.Join(dir, "started")
+	// The removed collector ignores SIGTERM, so the reload waits for the
+	// grace period until it is killed
+	writeConfig(t, path, map[string]string{"slow": "\"touch " + started + "; trap '' TERM; sleep 30\""})
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := NewExporterService(cfg, path, collector.NewCollectorManager(cfg))
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+	waitForFile(t, started)
+	_, loaded := es.ReloadStatus()
+
+	writeConfig(t, path, map[string]string{"fast": "\"echo up 1\""})
+	reloaded := make(chan error, 1)
+	go func() {
+		reloaded <- es.Reload()
+	}()
+	time.Sleep(500 * time.Millisecond)
+
+	begin := time.Now()
+	es.ReloadStatus()
+	es.CurrentConfig()
+	if elapsed := time.Since(begin); elapsed > 100*time.Millisecond {
+		t.Errorf("ReloadStatus and CurrentConfig took %v during a reload", elapsed)
+	}
+	select {
+	case <-reloaded:
+		t.Fatal("the reload did not wait for the removed collector")
+	default:
+	}
+	if es.CurrentConfig() != cfg {
+		t.Error("the new configuration was published before it was applied")
+	}
+
+	if err := <-reloaded; err != nil {
+		t.Fatalf("Reload() = %v", err)
+	}
+	if ok, reloadTime := es.ReloadStatus(); !ok || !reloadTime.After(loaded) {
+		t.Errorf("ReloadStatus() = %v, %v, want a successful reload after %v", ok, reloadTime, loaded)
+	}
+	if _, ok := es.CurrentConfig().Clusters["test"].Collectors["fast"]; !ok {
+		t.Error("the new configuration is not in effect")
+	}
+}
@@ -0,0 +1,252 @@
+// Author: mmwei3
+// Email: mmwei3@iflytek.com
+// Date: 2025-04-03
+//
+// Description:
+// This file implements the FileWatcher, which reloads the configuration when
+// the config file changes and re-runs collectors whose script changed.
+// Directories are watched rather than files, so that files replaced by an
+// atomic rename or a Kubernetes ConfigMap symlink swap are still noticed.
+
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"public_exporter/config"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// FileWatcher watches the configuration file and collector scripts
+type FileWatcher struct {
+	service *ExporterService
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+
+	mu           sync.Mutex
+	debounce     time.Duration
+	watchConfig  bool
+	includes     []string          // include patterns of the configuration
+	includeGlobs []string          // the include patterns, resolved
+	configHash   string            // hash of the config file and its includes
+	scriptHashes map[string]string // script file -> content hash
+	names        map[string]bool   // base names of the watched files
+	dirs         map[string]bool
+}
+
+// NewFileWatcher creates a FileWatcher for the service's current configuration
+func NewFileWatcher(es *ExporterService) (*FileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &FileWatcher{
+		service:      es,
+		watcher:      watcher,
+		done:         make(chan struct{}),
+		scriptHashes: make(map[string]string),
+		dirs:         make(map[string]bool),
+	}, nil
+}
+
+// Start begins watching the files of cfg
+func (fw *FileWatcher) Start(cfg *config.Config) {
+	fw.refresh(cfg)
+	go fw.run()
+}
+
+// Stop stops watching
+func (fw *FileWatcher) Stop() {
+	fw.watcher.Close()
+	<-fw.done
+}
+
+// refresh updates the watched files after the configuration changed
+func (fw *FileWatcher) refresh(cfg *config.Config) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	fw.debounce = time.Duration(cfg.Global.WatchDebounceMs) * time.Millisecond
+
+	var files []string
+	dirs := make(map[string]bool)
+	fw.watchConfig = cfg.Global.WatchConfig
+	fw.includes = cfg.Include
+	fw.includeGlobs = nil
+	if fw.watchConfig {
+		fw.configHash = fw.hashConfig()
+		files = append(files, cfg.Files...)
+		// Also watch the include directories, for files added to them
+		for _, pattern := range cfg.Include {
+			pattern = config.IncludePattern(fw.service.ConfigPath, pattern)
+			fw.includeGlobs = append(fw.includeGlobs, pattern)
+			dirs[filepath.Dir(pattern)] = true
+		}
+	}
+
+	scriptHashes := make(map[string]string)
+	if cfg.Global.WatchScripts {
+		for _, clusterCfg := range cfg.Clusters {
+			if !clusterCfg.Enabled {
+				continue
+			}
+			for _, collectorCfg := range clusterCfg.Collectors {
+				scriptFile := collectorCfg.ScriptFile()
+				if !collectorCfg.Enabled || scriptFile == "" {
+					continue
+				}
+				if hash, ok := fw.scriptHashes[scriptFile]; ok {
+					scriptHashes[scriptFile] = hash
+				} else {
+					scriptHashes[scriptFile] = hashFile(scriptFile)
+				}
+				files = append(files, scriptFile)
+			}
+		}
+	}
+	fw.scriptHashes = scriptHashes
+
+	// Watch the directory of each file and, if it is a symlink, of its target
+	fw.names = make(map[string]bool)
+	for _, file := range files {
+		fw.names[filepath.Base(file)] = true
+		dirs[filepath.Dir(file)] = true
+		if resolved, err := filepath.EvalSymlinks(file); err == nil {
+			fw.names[filepath.Base(resolved)] = true
+			dirs[filepath.Dir(resolved)] = true
+		}
+	}
+	for dir := range fw.dirs {
+		if !dirs[dir] {
+			fw.watcher.Remove(dir)
+			delete(fw.dirs, dir)
+		}
+	}
+	for dir := range dirs {
+		if fw.dirs[dir] {
+			continue
+		}
+		if err := fw.watcher.Add(dir); err != nil {
+			log.Printf("Failed to watch %s: %v", dir, err)
+			continue
+		}
+		fw.dirs[dir] = true
+	}
+}
+
+// run handles file events until the watcher is closed. Changes are acted on
+// once no further event arrived for the debounce period.
+func (fw *FileWatcher) run() {
+	defer close(fw.done)
+
+	var fire <-chan time.Time
+	for {
+		select {
+		case event, ok := <-fw.watcher.Events:
+			if !ok {
+				return
+			}
+			if fw.relevant(event.Name) {
+				fw.mu.Lock()
+				fire = time.After(fw.debounce)
+				fw.mu.Unlock()
+			}
+		case err, ok := <-fw.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("File watcher error: %v", err)
+		case <-fire:
+			fire = nil
+			fw.checkChanges()
+		}
+	}
+}
+
+// relevant reports whether an event for the named file may change a watched
+// file. Kubernetes swaps ConfigMap contents through "..data" style entries.
+func (fw *FileWatcher) relevant(name string) bool {
+	base := filepath.Base(name)
+	if strings.HasPrefix(base, "..") {
+		return true
+	}
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	for _, pattern := range fw.includeGlobs {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return fw.names[base]
+}
+
+// checkChanges compares the watched files with their last known content,
+// reloading the configuration or re-running collectors as needed
+func (fw *FileWatcher) checkChanges() {
+	fw.mu.Lock()
+	reloadConfig := false
+	if fw.watchConfig {
+		if hash := fw.hashConfig(); hash != "" && hash != fw.configHash {
+			fw.configHash = hash
+			reloadConfig = true
+		}
+	}
+	var changedScripts []string
+	for scriptFile, oldHash := range fw.scriptHashes {
+		hash := hashFile(scriptFile)
+		if hash == oldHash {
+			continue
+		}
+		fw.scriptHashes[scriptFile] = hash
+		if hash != "" {
+			changedScripts = append(changedScripts, scriptFile)
+		}
+	}
+	fw.mu.Unlock()
+
+	// Reload refreshes the watcher, so fw.mu must not be held here
+	if reloadConfig {
+		log.Printf("Configuration file %s changed", fw.service.ConfigPath)
+		fw.service.Reload()
+	}
+	for _, scriptFile := range changedScripts {
+		keys := fw.service.CollectorManager.TriggerScript(scriptFile)
+		if len(keys) > 0 {
+			log.Printf("Script %s changed, re-running %s", scriptFile, strings.Join(keys, ", "))
+		}
+	}
+}
+
+// hashConfig returns the hash of the configuration file and the files its
+// include patterns currently match, or "" if the file can't be read.
+// The caller must hold fw.mu.
+func (fw *FileWatcher) hashConfig() string {
+	configHash := hashFile(fw.service.ConfigPath)
+	if configHash == "" {
+		return ""
+	}
+	files, _ := config.IncludeFiles(fw.service.ConfigPath, fw.includes)
+	sum := sha256.New()
+	sum.Write([]byte(configHash))
+	for _, file := range files {
+		sum.Write([]byte(file + "\x00" + hashFile(file)))
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// hashFile returns the hash of a file's content, or "" if it can't be read
+func hashFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,256 @@
+//go:build !windows
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"public_exporter/collector"
+	"public_exporter/config"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// watchDebounce is the watch_debounce_ms of the test configurations
+const watchDebounce = 100 * time.Millisecond
+
+// logCounter counts lines logged by the exporter
+type logCounter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *logCounter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *logCounter) count(text string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Count(l.buf.String(), text)
+}
+
+// reloads returns how often the configuration was reloaded
+func (l *logCounter) reloads() int {
+	return l.count("Configuration reloaded successfully")
+}
+
+func captureLog(t *testing.T) *logCounter {
+	l := &logCounter{}
+	log.SetOutput(l)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return l
+}
+
+// watchedConfig returns a configuration watching itself and the script of
+// its collector, which records its runs in dir/runs
+func watchedConfig(dir string, includes ...string) string {
+	lines := []string{
+		"global:",
+		"  log_file: " + filepath.Join(dir, "exporter.log"),
+		"  watch_config: true",
+		"  watch_scripts: true",
+		fmt.Sprintf("  watch_debounce_ms: %d", watchDebounce/time.Millisecond),
+	}
+	if len(includes) > 0 {
+		lines = append(lines, "include: ["+strings.Join(includes, ", ")+"]")
+	}
+	lines = append(lines,
+		"clusters:",
+		"  test:",
+		"    enabled: true",
+		"    collectors:",
+		"      check:",
+		"        enabled: true",
+		"        interval: 3600",
+		"        script_type: custom",
+		"        interpreter: sh",
+		"        script_path: "+filepath.Join(dir, "check.sh"),
+	)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startWatching writes the collector's script and starts a service for the
+// configuration at path
+func startWatching(t *testing.T, dir, path string) *ExporterService {
+	t.Helper()
+	writeFile(t, filepath.Join(dir, "check.sh"), "echo run >> "+filepath.Join(dir, "runs")+"\necho up 1\n")
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := NewExporterService(cfg, path, collector.NewCollectorManager(cfg))
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(es.Stop)
+	waitFor(t, "the first run", func() bool { return runs(dir) == 1 })
+	return es
+}
+
+// runs returns how often the collector's script ran
+func runs(dir string) int {
+	data, _ := os.ReadFile(filepath.Join(dir, "runs"))
+	return strings.Count(string(data), "run\n")
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// settle waits long enough for the watcher to act on any pending change
+func settle() {
+	time.Sleep(5 * watchDebounce)
+}
+
+func TestFileWatcherDebounces(t *testing.T) {
+	logs := captureLog(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, watchedConfig(dir))
+	startWatching(t, dir, path)
+
+	// Writes closer together than the debounce period cause one reload,
+	// once they stopped
+	for i := 0; i < 5; i++ {
+		writeFile(t, path, watchedConfig(dir)+fmt.Sprintf("# edit %d\n", i))
+		time.Sleep(watchDebounce / 3)
+	}
+	if n := logs.reloads(); n != 0 {
+		t.Errorf("%d reloads while the file was still being written", n)
+	}
+	settle()
+	if n := logs.reloads(); n != 1 {
+		t.Errorf("%d reloads, want 1", n)
+	}
+
+	// Writing the same content again changes nothing
+	writeFile(t, path, watchedConfig(dir)+"# edit 4\n")
+	settle()
+	if n := logs.reloads(); n != 1 {
+		t.Errorf("%d reloads after rewriting the same content, want 1", n)
+	}
+}
+
+func TestFileWatcherAtomicRename(t *testing.T) {
+	logs := captureLog(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, watchedConfig(dir))
+	startWatching(t, dir, path)
+
+	for i := 1; i <= 2; i++ {
+		tmp := filepath.Join(dir, ".config.yaml.tmp")
+		writeFile(t, tmp, watchedConfig(dir)+fmt.Sprintf("# version %d\n", i))
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, fmt.Sprintf("reload %d", i), func() bool { return logs.reloads() == i })
+	}
+}
+
+func TestFileWatcherConfigMapSwap(t *testing.T) {
+	logs := captureLog(t)
+	dir := t.TempDir()
+	// Kubernetes mounts a ConfigMap as symlinks into a timestamped directory
+	// and swaps the ..data symlink to update it
+	swap := func(version string) {
+		versionDir := filepath.Join(dir, version)
+		if err := os.Mkdir(versionDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(versionDir, "config.yaml"), watchedConfig(dir)+"# "+version+"\n")
+		tmp := filepath.Join(dir, "..data_tmp")
+		if err := os.Symlink(version, tmp); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	swap("..2024_01_01")
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.Symlink(filepath.Join("..data", "config.yaml"), path); err != nil {
+		t.Fatal(err)
+	}
+	startWatching(t, dir, path)
+
+	swap("..2024_01_02")
+	waitFor(t, "the first reload", func() bool { return logs.reloads() == 1 })
+	swap("..2024_01_03")
+	waitFor(t, "the second reload", func() bool { return logs.reloads() == 2 })
+}
+
+func TestFileWatcherFollowsIncludeDirectories(t *testing.T) {
+	logs := captureLog(t)
+	dir := t.TempDir()
+	for _, sub := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, watchedConfig(dir, "a/*.yaml"))
+	startWatching(t, dir, path)
+
+	writeFile(t, filepath.Join(dir, "a", "one.yaml"), "templates: {}\n")
+	waitFor(t, "the reload for a file added to an include directory", func() bool { return logs.reloads() == 1 })
+	writeFile(t, filepath.Join(dir, "b", "two.yaml"), "templates: {}\n")
+	settle()
+	if n := logs.reloads(); n != 1 {
+		t.Errorf("%d reloads after a change outside the include directories, want 1", n)
+	}
+
+	// Once the configuration includes b instead of a, only b is watched
+	writeFile(t, path, watchedConfig(dir, "b/*.yaml"))
+	waitFor(t, "the reload for the new include", func() bool { return logs.reloads() == 2 })
+	writeFile(t, filepath.Join(dir, "a", "three.yaml"), "templates: {}\n")
+	settle()
+	if n := logs.reloads(); n != 2 {
+		t.Errorf("%d reloads after a change in a directory no longer included, want 2", n)
+	}
+	writeFile(t, filepath.Join(dir, "b", "four.yaml"), "templates: {}\n")
+	waitFor(t, "the reload for a file added to the new include directory", func() bool { return logs.reloads() == 3 })
+}
+
+func TestFileWatcherRerunsChangedScripts(t *testing.T) {
+	logs := captureLog(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, watchedConfig(dir))
+	startWatching(t, dir, path)
+
+	script := filepath.Join(dir, "check.sh")
+	data, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, script, string(data)+"# changed\n")
+	waitFor(t, "the collector to run again", func() bool { return runs(dir) == 2 })
+	settle()
+	if n := runs(dir); n != 2 {
+		t.Errorf("the collector ran %d times, want 2", n)
+	}
+	if n := logs.reloads(); n != 0 {
+		t.Errorf("%d reloads after a script change, want none", n)
+	}
+}